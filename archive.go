@@ -0,0 +1,322 @@
+package downtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveTimeFormat names archives after the newest event they contain, archives written by older
+// versions have no fractional seconds
+const archiveTimeFormat = "20060102T150405.999999999Z"
+
+// Archive is a gzip compressed segment of a database holding events rotated out of the live file.
+type Archive struct {
+	Path string
+	// Newest is the time of the newest event in the archive, truncated to the second for older archives.
+	Newest time.Time
+	// seq tells archives with the same Newest apart
+	seq int
+}
+
+// Archives lists the archives of the database at path, oldest first.
+func Archives(path string) ([]Archive, error) {
+	prefix := filepath.Base(path) + "."
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	archives := []Archive{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		seq := 0
+		if i := strings.LastIndexByte(stamp, '.'); i != -1 {
+			n, err := strconv.Atoi(stamp[i+1:])
+			if err == nil {
+				stamp, seq = stamp[:i], n
+			}
+		}
+		newest, err := time.Parse(archiveTimeFormat, stamp)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		archives = append(archives, Archive{
+			Path:   filepath.Join(filepath.Dir(path), name),
+			Newest: newest,
+			seq:    seq,
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if archives[i].Newest.Equal(archives[j].Newest) {
+			return archives[i].seq < archives[j].seq
+		}
+		return archives[i].Newest.Before(archives[j].Newest)
+	})
+	return archives, nil
+}
+
+// archivePath returns a free path for an archive of the database at path whose newest event is at newest
+func archivePath(path string, newest time.Time) (string, error) {
+	stamp := newest.UTC().Format(archiveTimeFormat)
+	archive := fmt.Sprintf("%s.%s.gz", path, stamp)
+	for seq := 1; ; seq++ {
+		_, err := os.Stat(archive)
+		if errors.Is(err, os.ErrNotExist) {
+			return archive, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		// e.g. rotated again after dying before the live file was replaced
+		archive = fmt.Sprintf("%s.%s.%d.gz", path, stamp, seq)
+	}
+}
+
+// Events reads all events from the archive.
+func (a Archive) Events() ([]Event, error) {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", a.Path, err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", a.Path, err)
+	}
+	r := NewDatabaseReader(bytes.NewReader(data))
+	r.SkipCorrupt(func(err *RecordError) {
+		logger.Warningf("skipping unreadable %s in %s", err, a.Path)
+	})
+	return r.All()
+}
+
+// RotateDatabase moves the events of the database at path that happened before the given time into
+// a new compressed archive next to it, and returns the path of the archive.
+// Nothing is written and the returned path is empty if there is nothing to rotate.
+func RotateDatabase(path string, before time.Time) (string, error) {
+	var archive string
+	var rotated int
+	err := rewriteDatabase(path, func(err *RecordError) {
+		logger.Warningf("dropping unreadable %s while rotating %s", err, path)
+	}, func(events []Event) ([]Event, error) {
+		old, live := []Event{}, []Event{}
+		var newest time.Time
+		for _, event := range events {
+			when := event.When.AsTime()
+			if !when.Before(before) {
+				live = append(live, event)
+				continue
+			}
+			old = append(old, event)
+			if when.After(newest) {
+				newest = when
+			}
+		}
+		if len(old) == 0 {
+			return nil, nil
+		}
+
+		var err error
+		archive, err = archivePath(path, newest)
+		if err != nil {
+			return nil, err
+		}
+		rotated = len(old)
+		// the archive is complete before the live file is replaced, if we die in between
+		// the events are in both places and OpenDatabaseSegments ignores the duplicates
+		return live, writeArchive(archive, old)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to rotate %s: %w", path, err)
+	}
+	if archive != "" {
+		logger.Infof("rotated %d events from %s into %s", rotated, path, archive)
+	}
+	return archive, nil
+}
+
+func writeArchive(path string, events []Event) error {
+	_, err := os.Stat(path)
+	if err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	w := NewDatabaseWriter(gz)
+	for _, event := range events {
+		err = w.Append(event)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = gz.Close()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PruneArchives deletes the archives of the database at path whose newest event is before the given time,
+// and returns the paths of the deleted archives.
+func PruneArchives(path string, before time.Time) ([]string, error) {
+	archives, err := Archives(path)
+	if err != nil {
+		return nil, err
+	}
+	pruned := []string{}
+	for _, archive := range archives {
+		if !archive.Newest.Before(before) {
+			continue
+		}
+		err = os.Remove(archive.Path)
+		if err != nil {
+			return pruned, err
+		}
+		logger.Infof("removed archive %s", archive.Path)
+		pruned = append(pruned, archive.Path)
+	}
+	return pruned, nil
+}
+
+// OpenDatabaseSegments opens the database at path along with its archives, the reader returns the
+// archived events followed by the live ones. Unreadable records are skipped with a warning.
+// Without any archives this is the same as OpenDatabaseReader.
+func OpenDatabaseSegments(path string) (*DatabaseReader, error) {
+	return OpenDatabaseSegmentsSince(path, time.Time{})
+}
+
+// OpenDatabaseSegmentsSince is OpenDatabaseSegments leaving out the archives that only hold events
+// before since, so reading recent events does not decompress the whole history.
+func OpenDatabaseSegmentsSince(path string, since time.Time) (*DatabaseReader, error) {
+	all, err := Archives(path)
+	if err != nil {
+		return nil, err
+	}
+	archives := []Archive{}
+	for _, archive := range all {
+		// older archives are named to the second only
+		if since.IsZero() || archive.Newest.Add(time.Second).After(since) {
+			archives = append(archives, archive)
+		}
+	}
+	if len(archives) == 0 {
+		r, err := OpenDatabaseReader(path)
+		if err != nil {
+			return nil, err
+		}
+		r.SkipCorrupt(func(err *RecordError) {
+			logger.Warningf("skipping unreadable %s in %s", err, path)
+		})
+		return r, nil
+	}
+
+	events := []Event{}
+	archived := map[Event]bool{}
+	for _, archive := range archives {
+		archiveEvents, err := archive.Events()
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range archiveEvents {
+			archived[event] = true
+		}
+		events = append(events, archiveEvents...)
+	}
+
+	r, err := OpenDatabaseReader(path)
+	if err == nil {
+		r.SkipCorrupt(func(err *RecordError) {
+			logger.Warningf("skipping unreadable %s in %s", err, path)
+		})
+		var liveEvents []Event
+		liveEvents, err = r.All()
+		r.Close()
+		for _, event := range liveEvents {
+			if !archived[event] {
+				events = append(events, event)
+			}
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	w := NewDatabaseWriter(buf)
+	for _, event := range events {
+		err = w.Append(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewDatabaseReader(bytes.NewReader(buf.Bytes())), nil
+}
+
+// LastSegmentEvents is LastServiceEvents for the database at path along with its archives,
+// the archives are only read if the live file holds fewer than n outages of service.
+func LastSegmentEvents(path, service string, n int) ([]Event, error) {
+	r, err := OpenDatabaseReader(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		r.SkipCorrupt(func(err *RecordError) {
+			logger.Warningf("skipping unreadable %s in %s", err, path)
+		})
+		events, err := LastServiceEvents(r, service, n)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		starts := 0
+		for _, event := range events {
+			if outageStart(event) {
+				starts++
+			}
+		}
+		if starts >= n {
+			return events, nil
+		}
+	}
+
+	segments, err := OpenDatabaseSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	defer segments.Close()
+	return LastServiceEvents(segments, service, n)
+}
@@ -0,0 +1,156 @@
+package downtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateDatabase(t *testing.T) {
+	legacy, err := os.ReadFile("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	err = os.WriteFile(path, legacy, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := downtime.OpenDatabaseReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEvents, err := r.All()
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keep a writer open across the rotation like downtimed would
+	w, err := downtime.OpenDatabaseWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	cutoff := time.Unix(1630000000, 0)
+	archive, err := downtime.RotateDatabase(path, cutoff)
+	assert.NoError(t, err)
+	assert.FileExists(t, archive)
+
+	archive, err = downtime.RotateDatabase(path, cutoff)
+	assert.NoError(t, err)
+	assert.Empty(t, archive, "nothing left to rotate")
+
+	live, err := downtime.OpenDatabaseReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	liveEvents, err := live.All()
+	assert.NoError(t, err)
+	for _, event := range liveEvents {
+		assert.False(t, event.When.AsTime().Before(cutoff))
+	}
+
+	extra := downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1646000000, 0))
+	assert.NoError(t, w.Append(extra))
+	expectedEvents = append(expectedEvents, extra)
+
+	segments, err := downtime.OpenDatabaseSegments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allEvents, err := segments.All()
+	assert.NoError(t, err)
+	assert.Equal(t, expectedEvents, allEvents)
+
+	pruned, err := downtime.PruneArchives(path, cutoff.Add(-time.Hour*24*30))
+	assert.NoError(t, err)
+	assert.Empty(t, pruned)
+	pruned, err = downtime.PruneArchives(path, cutoff)
+	assert.NoError(t, err)
+	assert.Len(t, pruned, 1)
+	archives, err := downtime.Archives(path)
+	assert.NoError(t, err)
+	assert.Empty(t, archives)
+}
+
+func TestRotateDatabaseSameSecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	base := time.Unix(1633484567, 0)
+	events := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeCrash, base.Add(100*time.Millisecond)),
+		downtime.NewEvent(downtime.EventTypeUp, base.Add(200*time.Millisecond)),
+		downtime.NewEvent(downtime.EventTypeCrash, base.Add(300*time.Millisecond)),
+		downtime.NewEvent(downtime.EventTypeUp, base.Add(time.Hour)),
+	}
+	require.NoError(t, downtime.WriteDatabase(path, events))
+
+	first, err := downtime.RotateDatabase(path, base.Add(150*time.Millisecond))
+	require.NoError(t, err)
+	second, err := downtime.RotateDatabase(path, base.Add(time.Second))
+	require.NoError(t, err, "the newest events are in the same second")
+	assert.NotEqual(t, first, second)
+
+	// rotating the same events again, e.g. after dying before the live file was replaced
+	require.NoError(t, downtime.WriteDatabase(path, events[2:]))
+	third, err := downtime.RotateDatabase(path, base.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, second[:len(second)-len(".gz")]+".1.gz", third)
+
+	archives, err := downtime.Archives(path)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, archive := range archives {
+		paths = append(paths, archive.Path)
+	}
+	assert.Equal(t, []string{first, second, third}, paths)
+
+	segments, err := downtime.OpenDatabaseSegments(path)
+	require.NoError(t, err)
+	defer segments.Close()
+	all, err := segments.All()
+	require.NoError(t, err)
+	assert.Equal(t, []downtime.Event{events[0], events[1], events[2], events[2], events[3]}, all)
+}
+
+func TestOpenDatabaseSegmentsSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	base := time.Unix(1633484567, 0)
+	events := []downtime.Event{}
+	for i := 0; i < 4; i++ {
+		down := base.Add(time.Duration(i) * time.Hour)
+		events = append(events,
+			downtime.NewEvent(downtime.EventTypeCrash, down),
+			downtime.NewEvent(downtime.EventTypeUp, down.Add(time.Minute)))
+	}
+	require.NoError(t, downtime.WriteDatabase(path, events))
+	_, err := downtime.RotateDatabase(path, base.Add(2*time.Hour))
+	require.NoError(t, err)
+	archives, err := downtime.Archives(path)
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	// only reading the archive fails now
+	require.NoError(t, os.WriteFile(archives[0].Path, []byte("garbage"), 0644))
+
+	r, err := downtime.OpenDatabaseSegmentsSince(path, base.Add(2*time.Hour))
+	require.NoError(t, err, "the archive is not read")
+	defer r.Close()
+	recent, err := r.All()
+	require.NoError(t, err)
+	assert.Equal(t, events[4:], recent)
+	_, err = downtime.OpenDatabaseSegmentsSince(path, base.Add(time.Hour))
+	assert.Error(t, err)
+
+	last, err := downtime.LastSegmentEvents(path, "", 2)
+	require.NoError(t, err, "the live file holds the last 2 outages")
+	assert.Equal(t, events[4:], last)
+	_, err = downtime.LastSegmentEvents(path, "", 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package downtime
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// BootID identifies a boot of the system, on Linux it is the random UUID the kernel generates
+// on every boot, which journald uses to tell boots apart.
+type BootID [16]byte
+
+func (id BootID) IsZero() bool {
+	return id == BootID{}
+}
+
+// String formats the ID as a UUID, as in /proc/sys/kernel/random/boot_id, or "" if it is zero.
+func (id BootID) String() string {
+	if id.IsZero() {
+		return ""
+	}
+	s := hex.EncodeToString(id[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// ParseBootID parses a boot ID formatted as a UUID or as 32 hex digits like journalctl --list-boots shows it.
+func ParseBootID(s string) (BootID, error) {
+	var id BootID
+	b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(s), "-", ""))
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("%q is not a valid boot ID", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// SystemBootID returns the ID of the current boot of the system.
+func SystemBootID() (BootID, error) {
+	id, err := bootID()
+	if err != nil {
+		return id, fmt.Errorf("unable to determine boot ID: %w", err)
+	}
+	return id, nil
+}
+
+// BootIDStore is implemented by data stores that can remember the boot ID, like DataDir.
+type BootIDStore interface {
+	SetBootID(id BootID) error
+	GetBootID() (BootID, error)
+}
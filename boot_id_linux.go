@@ -0,0 +1,13 @@
+package downtime
+
+import "os"
+
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+func bootID() (BootID, error) {
+	b, err := os.ReadFile(bootIDFile)
+	if err != nil {
+		return BootID{}, err
+	}
+	return ParseBootID(string(b))
+}
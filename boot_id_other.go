@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package downtime
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func bootID() (BootID, error) {
+	return BootID{}, fmt.Errorf("os not supported: %s", runtime.GOOS)
+}
@@ -0,0 +1,36 @@
+package downtime_test
+
+import (
+	"testing"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBootID(t *testing.T) {
+	id, err := downtime.ParseBootID("4c6b0b3e-5f5a-4d36-8e6b-7a3f0c1d2e9f\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "4c6b0b3e-5f5a-4d36-8e6b-7a3f0c1d2e9f", id.String())
+
+	journald, err := downtime.ParseBootID("4c6b0b3e5f5a4d368e6b7a3f0c1d2e9f")
+	assert.NoError(t, err)
+	assert.Equal(t, id, journald)
+
+	_, err = downtime.ParseBootID("4c6b0b3e")
+	assert.Error(t, err)
+	assert.Equal(t, "", downtime.BootID{}.String())
+}
+
+func TestDataDirBootID(t *testing.T) {
+	dd, err := downtime.NewDataDir(t.TempDir())
+	assert.NoError(t, err)
+	_, err = dd.GetBootID()
+	assert.Error(t, err)
+
+	id, err := downtime.ParseBootID("4c6b0b3e-5f5a-4d36-8e6b-7a3f0c1d2e9f")
+	assert.NoError(t, err)
+	assert.NoError(t, dd.SetBootID(id))
+	stored, err := dd.GetBootID()
+	assert.NoError(t, err)
+	assert.Equal(t, id, stored)
+}
@@ -19,3 +19,12 @@ func SystemBootTime() (time.Time, error) {
 func ProcessBootTime() time.Time {
 	return startTime
 }
+
+// ProcessStartTime returns when the process with the given PID started, it fails if there is no such process.
+func ProcessStartTime(pid int) (time.Time, error) {
+	st, err := processStartTime(pid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to determine start time of process %d: %w", pid, err)
+	}
+	return st, nil
+}
@@ -3,9 +3,11 @@ package downtime
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/benbjohnson/clock"
+	"github.com/juju/loggo"
 )
 
 func NewDaemon(dataStore DataStore, database EventWriter, sleep time.Duration) *Daemon {
@@ -17,7 +19,11 @@ func NewDaemonWithClock(dataStore DataStore, database EventWriter, sleep time.Du
 		dataStore: dataStore,
 		database:  database,
 		sleep:     sleep,
-		clk:       clk,
+		clockWatch: clockWatch{
+			clk:  clk,
+			mono: SystemMonotonicClock(),
+		},
+		logger: logger,
 	}
 }
 
@@ -25,10 +31,61 @@ type Daemon struct {
 	dataStore DataStore
 	database  EventWriter
 	sleep     time.Duration
-	clk       clock.Clock
+	clockWatch
+	hooks    map[EventType][]Hook
+	bootID   BootID
+	shutdown ShutdownDetector
+	kinds    ShutdownKindSource
+	// service is the name of the service tracked by a MultiTracker, empty for the system
+	service string
+	logger  loggo.Logger
+
+	mu             sync.Mutex
+	subscribers    map[int]func(Notification)
+	nextSubscriber int
+	lastOutage     *OutageReport
+	bootTime       time.Time
+	lastStamp      time.Time
+	stampErr       error
+}
+
+// OutageReport describes an outage detected by Daemon.Init.
+type OutageReport struct {
+	Outage
+	PreviousUptime time.Duration
+	Downtime       time.Duration
+}
+
+// Hook is run by the daemon after it recorded an outage.
+type Hook interface {
+	Run(report OutageReport) error
+}
+
+// AddHook runs hook whenever an outage that began with an event of type what is detected,
+// what is either EventTypeCrash or EventTypeShutdown. With EventTypeUp hook runs for every
+// outage, including those where it is not known how the system went down.
+func (d *Daemon) AddHook(what EventType, hook Hook) {
+	if d.hooks == nil {
+		d.hooks = map[EventType][]Hook{}
+	}
+	d.hooks[what] = append(d.hooks[what], hook)
 }
 
-func (d Daemon) Init(bootTime time.Time, timeFormat string) error {
+func (d *Daemon) runHooks(report OutageReport) {
+	for _, what := range []EventType{report.Down.What, EventTypeUp} {
+		if what == EventTypeNone {
+			continue
+		}
+		for _, hook := range d.hooks[what] {
+			err := hook.Run(report)
+			if err != nil {
+				d.logger.Errorf("%s hook failed: %s", what, err)
+			}
+		}
+	}
+}
+
+func (d *Daemon) Init(bootTime time.Time, timeFormat string) error {
 	err := d.report(bootTime, timeFormat)
 	if err != nil {
 		return fmt.Errorf("error reporting: %w", err)
@@ -37,57 +94,351 @@ func (d Daemon) Init(bootTime time.Time, timeFormat string) error {
 	if err != nil {
 		return fmt.Errorf("error updating boot time: %w", err)
 	}
+	if store, ok := d.dataStore.(BootIDStore); ok && !d.bootID.IsZero() {
+		err = store.SetBootID(d.bootID)
+		if err != nil {
+			return fmt.Errorf("error updating boot ID: %w", err)
+		}
+	}
+	d.mu.Lock()
+	d.bootTime = bootTime
+	d.mu.Unlock()
 	return nil
 }
 
-func (d Daemon) Run(ctx context.Context) error {
+/*
+SetBootID tells the daemon the ID of the current boot, usually SystemBootID. It is stored with the Up
+event of every outage, and if the data store is a BootIDStore, comparing it with the ID stored by the
+previous run decides whether the system was rebooted. Without it that is guessed from the boot time.
+*/
+func (d *Daemon) SetBootID(id BootID) {
+	d.bootID = id
+}
+
+// sameBoot compares the boot ID with the one stored by the previous run, known is false if either is missing
+func (d *Daemon) sameBoot() (same, known bool) {
+	store, ok := d.dataStore.(BootIDStore)
+	if !ok || d.bootID.IsZero() {
+		return false, false
+	}
+	old, err := store.GetBootID()
+	if err != nil {
+		d.logger.Warningf("could not read old boot ID: %s", err)
+		return false, false
+	}
+	return old == d.bootID, true
+}
+
+// SetMonotonicClock replaces the clocks used to detect suspends, by default SystemMonotonicClock.
+func (d *Daemon) SetMonotonicClock(mono MonotonicClock) {
+	d.mono = mono
+}
+
+/*
+SetShutdownDetector lets the daemon tell whether the system is going down when it is stopped. If it is
+not, the daemon records a MonitorStop event instead of the shutdown, so the next reboot is not taken
+for a shutdown at that time, or for a crash if the system went down while the daemon was not running.
+Without it every stop of the daemon is taken for a shutdown.
+*/
+func (d *Daemon) SetShutdownDetector(detector ShutdownDetector) {
+	d.shutdown = detector
+}
+
+// SetShutdownKindSource tells the daemon how to find out what kind of shutdown it records, without it
+// the kind is unknown. The kind is kept in the data store if it is a ShutdownKindStore.
+func (d *Daemon) SetShutdownKindSource(source ShutdownKindSource) {
+	d.kinds = source
+}
+
+// MinSuspendDuration is the shortest suspend the daemon records, shorter differences between the clocks are noise.
+const MinSuspendDuration = 5 * time.Second
+
+// MinClockJump is the smallest step of the wall clock the daemon records, NTP slews smaller offsets anyway.
+const MinClockJump = time.Second
+
+func (d *Daemon) Run(ctx context.Context) error {
+	d.mark()
 	d.stamp(false)
 	for {
 		select {
 		case <-d.clk.After(d.sleep):
-			d.stamp(false)
+			d.tick()
 		case <-ctx.Done():
-			d.stamp(true)
+			d.stop()
 			return ctx.Err()
 		}
 	}
 }
 
-func (d Daemon) stamp(shutdown bool) {
-	err := d.dataStore.SetStamp(d.clk.Now())
+// stop records the shutdown of the system, or that monitoring stopped if the system keeps running
+func (d *Daemon) stop() {
+	if !d.shuttingDown() {
+		d.stamp(false)
+		d.recordMonitorStop()
+		return
+	}
+	d.recordShutdownKind()
+	d.stamp(true)
+}
+
+// shuttingDown asks the ShutdownDetector whether the system is going down, if it can not tell it is assumed to be
+func (d *Daemon) shuttingDown() bool {
+	if d.shutdown == nil {
+		return true
+	}
+	down, err := d.shutdown.ShuttingDown()
 	if err != nil {
-		logger.Errorf("failed to update stamp: %w", err)
+		d.logger.Warningf("could not tell whether the system is going down, assuming it is: %s", err)
+		return true
 	}
-	if shutdown {
-		err = d.dataStore.SetShutdown(d.clk.Now())
+	return down
+}
+
+// recordShutdownKind keeps the kind of the shutdown, unknown ones too so the kind of an earlier shutdown
+// is not taken for this one
+func (d *Daemon) recordShutdownKind() {
+	store, ok := d.dataStore.(ShutdownKindStore)
+	if !ok {
+		return
+	}
+	kind := ShutdownKindUnknown
+	if d.kinds != nil {
+		var err error
+		kind, err = d.kinds.ShutdownKind()
 		if err != nil {
-			logger.Errorf("failed to update shutdown: %w", err)
+			d.logger.Warningf("could not tell the kind of shutdown: %s", err)
 		}
 	}
+	if kind != ShutdownKindUnknown {
+		d.logger.Infof("shutting down for %s", kind)
+	}
+	err := store.SetShutdownKind(kind)
+	if err != nil {
+		d.logger.Errorf("failed to update shutdown kind: %s", err)
+	}
 }
 
-func (d Daemon) updateDatabase(down, up time.Time, crashed bool) error {
-	downEvt := NewEvent(EventTypeShutdown, down)
-	if crashed {
-		downEvt.What = EventTypeCrash
+// shutdownKind returns the kind of the last shutdown kept in the data store
+func (d *Daemon) shutdownKind() ShutdownKind {
+	store, ok := d.dataStore.(ShutdownKindStore)
+	if !ok {
+		return ShutdownKindUnknown
+	}
+	kind, err := store.GetShutdownKind()
+	if err != nil {
+		d.logger.Warningf("could not read old shutdown kind: %s", err)
 	}
-	upEvt := NewEvent(EventTypeUp, up)
+	return kind
+}
 
-	err := d.database.Append(downEvt)
+/*
+recordMonitorStop records that the daemon stops while the system keeps running. The time is also
+kept in the data store if it is a MonitorStopStore, it is never before the last stamp so the next
+run knows the stamp is not when the system went down.
+*/
+func (d *Daemon) recordMonitorStop() {
+	now := d.clk.Now()
+	d.logger.Infof("system is not going down, monitoring stops")
+	err := d.database.Append(d.event(EventTypeMonitorStop, now))
 	if err != nil {
-		return err
+		d.logger.Errorf("failed to record monitor stop: %s", err)
+	}
+	if store, ok := d.dataStore.(MonitorStopStore); ok {
+		err = store.SetMonitorStop(now)
+		if err != nil {
+			d.logger.Errorf("failed to update monitor stop: %s", err)
+		}
+	}
+}
+
+// monitorStopped reports whether the previous run recorded stopping after the stamp
+func (d *Daemon) monitorStopped(stamp time.Time) bool {
+	store, ok := d.dataStore.(MonitorStopStore)
+	if !ok {
+		return false
 	}
-	return d.database.Append(upEvt)
+	stopped, err := store.GetMonitorStop()
+	return err == nil && !stopped.IsZero() && !stopped.Before(stamp)
 }
 
-func (d Daemon) report(bootTime time.Time, timeFormat string) error {
+// clockWatch compares the wall clock with the monotonic clocks at every tick to detect suspends
+// and steps of the wall clock
+type clockWatch struct {
+	clk  clock.Clock
+	mono MonotonicClock
+
+	// readings at the last tick
+	lastTick      time.Time
+	lastMonotonic time.Duration
+	lastBoottime  time.Duration
+}
+
+// mark remembers the clock readings to compare with at the next tick
+func (w *clockWatch) mark() {
+	// without the monotonic reading of time.Now, Sub compares the wall clock which is what can be stepped
+	w.lastTick = w.clk.Now().Round(0)
+	w.lastMonotonic = w.mono.Monotonic()
+	w.lastBoottime = w.mono.Boottime()
+}
+
+// elapse takes new readings and returns the time of the last tick along with how long the system was
+// suspended and how far the clock was stepped since, each zero if below MinSuspendDuration or MinClockJump
+func (w *clockWatch) elapse() (lastTick time.Time, suspended, jump time.Duration) {
+	lastTick, lastMonotonic, lastBoottime := w.lastTick, w.lastMonotonic, w.lastBoottime
+	w.mark()
+	elapsed := w.lastBoottime - lastBoottime
+	suspended = elapsed - (w.lastMonotonic - lastMonotonic)
+	if suspended < MinSuspendDuration {
+		suspended = 0
+	}
+	jump = w.lastTick.Sub(lastTick) - elapsed
+	if jump < MinClockJump && jump > -MinClockJump {
+		jump = 0
+	}
+	return lastTick, suspended, jump
+}
+
+// tick runs after every sleep, it records whether the system was suspended or the clock was stepped
+// since the last tick and updates the stamp
+func (d *Daemon) tick() {
+	d.advance()
+	d.stamp(false)
+}
+
+// advance records any suspend or step of the clock since the last tick, it returns the step, zero if none
+func (d *Daemon) advance() time.Duration {
+	lastTick, suspended, jump := d.elapse()
+	if suspended != 0 {
+		d.recordSuspend(lastTick, suspended)
+	}
+	if jump != 0 {
+		d.recordClockJump(d.lastTick, jump)
+	}
+	return jump
+}
+
+// recordClockJump records that the clock was stepped by offset and shifts the boot time along with it.
+func (d *Daemon) recordClockJump(now time.Time, offset time.Duration) {
+	d.logger.Warningf("clock jumped by %s", offset.String())
+	jump := NewClockJump(now, offset)
+	jump.Service = d.service
+	err := d.database.Append(jump)
+	if err != nil {
+		d.logger.Errorf("failed to record clock jump: %s", err)
+	}
+	d.shiftBoot(offset)
+}
+
+/*
+shiftBoot moves the boot time by the offset the clock was stepped by. The boot time is stored on the
+old clock, it is moved too so the uptime reported after the next boot is measured on the same clock as
+the stamps.
+*/
+func (d *Daemon) shiftBoot(offset time.Duration) {
+	boot, err := d.dataStore.GetBoot()
+	if err == nil {
+		err = d.dataStore.SetBoot(boot.Add(offset))
+	}
+	if err != nil {
+		d.logger.Errorf("failed to adjust boot time: %s", err)
+	}
+	d.mu.Lock()
+	if !d.bootTime.IsZero() {
+		d.bootTime = d.bootTime.Add(offset)
+	}
+	d.mu.Unlock()
+}
+
+// recordSuspend records a suspend of the given duration, when exactly the system was suspended since
+// the last tick is unknown so it is taken to be right after it
+func (d *Daemon) recordSuspend(lastTick time.Time, suspended time.Duration) {
+	suspend := Outage{
+		Down: d.event(EventTypeSuspend, lastTick),
+		Up:   d.event(EventTypeResume, lastTick.Add(suspended)),
+	}
+	d.logger.Infof("suspended for %s (%d seconds)", suspended.String(), int(suspended.Seconds()))
+	err := d.updateDatabase(suspend)
+	if err != nil {
+		d.logger.Errorf("failed to record suspend: %s", err)
+	}
+}
+
+func (d *Daemon) stamp(shutdown bool) {
+	now := d.clk.Now()
+	var failed error
+	err := d.dataStore.SetStamp(now)
+	stamped := err == nil
+	if err != nil {
+		failed = fmt.Errorf("failed to update stamp: %w", err)
+		d.logger.Errorf("%s", failed)
+		d.notify(Notification{Kind: NotifyStampFailed, Time: now, Err: failed})
+	}
+	if shutdown {
+		err = d.dataStore.SetShutdown(now)
+		if err != nil {
+			failed = fmt.Errorf("failed to update shutdown: %w", err)
+			d.logger.Errorf("%s", failed)
+			d.notify(Notification{Kind: NotifyStampFailed, Time: now, Err: failed})
+		}
+	}
+	d.mu.Lock()
+	if stamped {
+		d.lastStamp = now
+	}
+	d.stampErr = failed
+	d.mu.Unlock()
+	if failed == nil {
+		d.notify(Notification{Kind: NotifyStamp, Time: now})
+	}
+	if r, ok := d.database.(retrier); ok {
+		err = r.Retry()
+		if err != nil {
+			d.logger.Warningf("retrying queued events: %s", err)
+		}
+	}
+}
+
+// retrier is implemented by writers that queue events they could not write, like FanOutWriter
+type retrier interface {
+	Retry() error
+}
+
+// event creates an event of the tracked service
+func (d *Daemon) event(what EventType, when time.Time) Event {
+	event := NewEvent(what, when)
+	event.Service = d.service
+	return event
+}
+
+func (d *Daemon) updateDatabase(outage Outage) error {
+	if outage.Down.What != EventTypeNone {
+		err := d.database.Append(outage.Down)
+		if err != nil {
+			return err
+		}
+	}
+	return d.database.Append(outage.Up)
+}
+
+// sameBootTolerance is how far apart boot times can be and still be the same boot, the boot time
+// of the system is only known to the second and moves along with clock jumps
+const sameBootTolerance = 2 * time.Second
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (d *Daemon) report(bootTime time.Time, timeFormat string) error {
 	var stamp, shutdown, oldBoot time.Time
 	var haveStamp, haveShutdown, haveOldBoot bool
 	var oldUptime, downtime time.Duration
 
 	stamp, err := d.dataStore.GetStamp()
 	if err != nil {
-		logger.Warningf("could not read old stamp: %s", err)
+		d.logger.Warningf("could not read old stamp: %s", err)
 		haveStamp = false
 	} else {
 		haveStamp = true
@@ -95,7 +446,7 @@ func (d Daemon) report(bootTime time.Time, timeFormat string) error {
 
 	shutdown, err = d.dataStore.GetShutdown()
 	if err != nil {
-		logger.Warningf("could not read old shutdown: %s", err)
+		d.logger.Warningf("could not read old shutdown: %s", err)
 		haveShutdown = false
 	} else {
 		haveShutdown = true
@@ -103,14 +454,14 @@ func (d Daemon) report(bootTime time.Time, timeFormat string) error {
 
 	oldBoot, err = d.dataStore.GetBoot()
 	if err != nil {
-		logger.Warningf("could not read old boot: %s", err)
+		d.logger.Warningf("could not read old boot: %s", err)
 		haveOldBoot = false
 	} else {
 		haveOldBoot = true
 	}
 
 	if !haveStamp && !haveShutdown && !haveOldBoot {
-		logger.Infof("starting up first time, no knowledge of downtime")
+		d.logger.Infof("starting up first time, no knowledge of downtime")
 		return nil
 	}
 
@@ -134,23 +485,58 @@ func (d Daemon) report(bootTime time.Time, timeFormat string) error {
 		downtime = bootTime.Sub(stamp)
 	}
 
-	if downtime < 0 {
-		/*
-			* This happens if we quit and re-start the process (we
-				* normally only exit when system goes down.
-		*/
-		logger.Infof("daemon restarted, no downtime")
-		return nil
+	// the boot IDs tell for sure, the boot times of a fast reboot can be within the tolerance
+	restarted, known := d.sameBoot()
+	if !known {
+		restarted = absDuration(bootTime.Sub(oldBoot)) < sameBootTolerance
+	}
+	stopped := d.monitorStopped(stamp)
+	if restarted {
+		// the daemon was restarted without a reboot, if it did not record stopping it was killed
+		// and monitoring stopped after the last stamp
+		d.logger.Infof("daemon restarted, no downtime")
+		if !stopped {
+			err = d.database.Append(d.event(EventTypeMonitorStop, stamp))
+			if err != nil {
+				return err
+			}
+		}
+		return d.database.Append(d.event(EventTypeMonitorStart, d.clk.Now()))
 	}
 
-	if haveShutdown {
-		logger.Infof("shutdown at %s", shutdown.Format(timeFormat))
-		err = d.updateDatabase(shutdown, bootTime, false)
-	} else {
-		logger.Infof("crashed at %s", stamp.Format(timeFormat))
-		err = d.updateDatabase(stamp, bootTime, true)
+	up := d.event(EventTypeUp, bootTime)
+	up.BootID = d.bootID
+	report := OutageReport{
+		Outage: Outage{
+			Up: up,
+		},
+		PreviousUptime: oldUptime,
+		Downtime:       downtime,
+	}
+	switch {
+	case downtime < 0:
+		// rebooted, but the clock is behind the last stamp, e.g. restored from a saved time on
+		// a system without a real-time clock, so when it went down is unknown on this clock
+		d.logger.Warningf("clock is %s behind the last stamp, downtime unknown", (-downtime).String())
+		report.Downtime = 0
+	case stopped:
+		// the system kept running after the daemon stopped, so when it went down is unknown
+		d.logger.Warningf("monitoring stopped at %s, downtime unknown", stamp.Format(timeFormat))
+		report.Downtime = 0
+	case haveShutdown:
+		d.logger.Infof("shutdown at %s", shutdown.Format(timeFormat))
+		report.Down = d.event(EventTypeShutdown, shutdown)
+		report.Down.Kind = d.shutdownKind()
+	default:
+		d.logger.Infof("crashed at %s", stamp.Format(timeFormat))
+		report.Down = d.event(EventTypeCrash, stamp)
+	}
+	err = d.updateDatabase(report.Outage)
+	d.logger.Infof("previous uptime was %s (%d seconds)", oldUptime.String(), int(oldUptime.Seconds()))
+	if report.Down.What != EventTypeNone {
+		d.logger.Infof("downtime was %s (%d seconds", downtime.String(), int(downtime.Seconds()))
 	}
-	logger.Infof("previous uptime was %s (%d seconds)", oldUptime.String(), int(oldUptime.Seconds()))
-	logger.Infof("downtime was %s (%d seconds", downtime.String(), int(downtime.Seconds()))
+	d.notify(Notification{Kind: NotifyOutage, Time: d.clk.Now(), Outage: &report})
+	d.runHooks(report)
 	return err
 }
@@ -2,16 +2,23 @@ package downtime
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/benbjohnson/clock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockDataStore struct {
 	stamp, shutdown, boot time.Time
+	bootID                BootID
+	monitorStop           time.Time
+	shutdownKind          ShutdownKind
 	getErr, setErr        error
 }
 
@@ -51,6 +58,45 @@ func (ds *mockDataStore) GetBoot() (time.Time, error) {
 	return ds.boot, ds.getErr
 }
 
+func (ds *mockDataStore) SetBootID(id BootID) error {
+	if ds.setErr != nil {
+		return ds.setErr
+	}
+	ds.bootID = id
+	return nil
+}
+
+func (ds *mockDataStore) GetBootID() (BootID, error) {
+	if ds.bootID.IsZero() {
+		return ds.bootID, fmt.Errorf("no boot ID")
+	}
+	return ds.bootID, ds.getErr
+}
+
+func (ds *mockDataStore) SetMonitorStop(t time.Time) error {
+	if ds.setErr != nil {
+		return ds.setErr
+	}
+	ds.monitorStop = t
+	return nil
+}
+
+func (ds *mockDataStore) GetMonitorStop() (time.Time, error) {
+	return ds.monitorStop, ds.getErr
+}
+
+func (ds *mockDataStore) SetShutdownKind(kind ShutdownKind) error {
+	if ds.setErr != nil {
+		return ds.setErr
+	}
+	ds.shutdownKind = kind
+	return nil
+}
+
+func (ds *mockDataStore) GetShutdownKind() (ShutdownKind, error) {
+	return ds.shutdownKind, ds.getErr
+}
+
 func TestDaemonReporting(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
 	writer := NewDatabaseWriter(buff)
@@ -83,14 +129,14 @@ func TestDaemonReporting(t *testing.T) {
 	d.stamp(false)
 	err = d.Init(expectedEvents[1].When.AsTime(), time.Stamp)
 	assert.NoError(t, err)
-	assert.Len(t, buff.Bytes(), EventSize*2, "there should be 2 events at this point")
+	assert.Len(t, buff.Bytes(), HeaderSize+EventSize*2, "there should be 2 events at this point")
 
 	// generate second pair of events
 	clk.Set(expectedEvents[2].When.AsTime())
 	d.stamp(true)
 	err = d.Init(expectedEvents[3].When.AsTime(), time.Stamp)
 	assert.NoError(t, err)
-	assert.Len(t, buff.Bytes(), EventSize*4, "there should be 4 events at this point")
+	assert.Len(t, buff.Bytes(), HeaderSize+EventSize*4, "there should be 4 events at this point")
 
 	// verify events
 	r := NewDatabaseReader(bytes.NewReader(buff.Bytes()))
@@ -98,3 +144,344 @@ func TestDaemonReporting(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedEvents, generatedEvents)
 }
+
+// mockMonotonicClock is suspended by advancing only the boot time
+type mockMonotonicClock struct {
+	monotonic, boottime time.Duration
+}
+
+func (c *mockMonotonicClock) Monotonic() time.Duration { return c.monotonic }
+func (c *mockMonotonicClock) Boottime() time.Duration  { return c.boottime }
+
+func (c *mockMonotonicClock) Add(awake, suspended time.Duration) {
+	c.monotonic += awake
+	c.boottime += awake + suspended
+}
+
+func TestDaemonTicksOnWallClock(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	d := NewDaemonWithClock(new(mockDataStore), NewDatabaseWriter(buff), time.Millisecond, clock.New())
+	d.mark()
+	time.Sleep(10 * time.Millisecond)
+	assert.Zero(t, d.advance())
+	assert.True(t, d.lastTick == d.lastTick.Round(0), "ticks have no monotonic reading, it does not count suspends nor steps")
+	assert.Len(t, buff.Bytes(), 0)
+}
+
+func TestDaemonDetectsSuspend(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	mono := &mockMonotonicClock{}
+	start := time.Unix(1633484567, 0)
+	clk.Set(start)
+
+	d := NewDaemonWithClock(store, NewDatabaseWriter(buff), DefaultSleepSeconds*time.Second, clk)
+	d.SetMonotonicClock(mono)
+	d.mark()
+
+	sleep := DefaultSleepSeconds * time.Second
+	clk.Add(sleep)
+	mono.Add(sleep, 0)
+	d.tick()
+	assert.Len(t, buff.Bytes(), 0, "no suspend without a gap")
+
+	clk.Add(sleep + time.Second)
+	mono.Add(sleep, time.Second)
+	d.tick()
+	assert.Len(t, buff.Bytes(), 0, "gaps shorter than MinSuspendDuration are ignored")
+
+	lastTick := clk.Now()
+	clk.Add(sleep + time.Hour)
+	mono.Add(sleep, time.Hour)
+	d.tick()
+	assert.Equal(t, clk.Now(), store.stamp)
+
+	r := NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	events, err := r.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		NewEvent(EventTypeSuspend, lastTick),
+		NewEvent(EventTypeResume, lastTick.Add(time.Hour)),
+	}, events)
+}
+
+func TestDaemonDetectsClockJump(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	mono := &mockMonotonicClock{}
+	boot := time.Unix(1633484567, 0)
+	clk.Set(boot)
+
+	d := NewDaemonWithClock(store, NewDatabaseWriter(buff), DefaultSleepSeconds*time.Second, clk)
+	d.SetMonotonicClock(mono)
+	store.getErr = fmt.Errorf("test error")
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	store.getErr = nil
+	d.mark()
+
+	sleep := DefaultSleepSeconds * time.Second
+	clk.Add(sleep + 500*time.Millisecond)
+	mono.Add(sleep, 0)
+	d.tick()
+	assert.Len(t, buff.Bytes(), 0, "jumps shorter than MinClockJump are ignored")
+
+	// the clock is set back an hour
+	clk.Add(sleep - time.Hour)
+	mono.Add(sleep, 0)
+	d.tick()
+	assert.Equal(t, boot.Add(-time.Hour), store.boot, "the boot time follows the clock")
+	assert.Equal(t, boot.Add(-time.Hour), d.Status().Boot)
+
+	r := NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	events, err := r.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{NewClockJump(clk.Now(), -time.Hour)}, events)
+
+	// the daemon is restarted, the boot time of the system moved along with the clock
+	assert.NoError(t, d.Init(boot.Add(-time.Hour), time.Stamp))
+	assert.Len(t, buff.Bytes(), HeaderSize+EventSize*3, "a restart is a gap in monitoring, no outage")
+
+	// crash and reboot, the uptime is measured on the new clock
+	stamp := clk.Now()
+	var reports []OutageReport
+	d.Subscribe(func(n Notification) {
+		if n.Kind == NotifyOutage {
+			reports = append(reports, *n.Outage)
+		}
+	})
+	assert.NoError(t, d.Init(stamp.Add(time.Minute), time.Stamp))
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, 2*sleep+500*time.Millisecond, reports[0].PreviousUptime)
+		assert.Equal(t, time.Minute, reports[0].Downtime)
+		assert.Equal(t, EventTypeCrash, reports[0].Down.What)
+	}
+
+	// reboot with the clock behind the last stamp, as on systems without a real-time clock
+	clk.Set(stamp.Add(2 * time.Minute))
+	d.stamp(false)
+	assert.NoError(t, d.Init(stamp.Add(-time.Hour), time.Stamp))
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, EventTypeNone, reports[1].Down.What, "when it went down is unknown")
+		assert.Equal(t, time.Duration(0), reports[1].Downtime)
+	}
+	r = NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	events, err = r.All()
+	assert.NoError(t, err)
+	assert.Equal(t, NewEvent(EventTypeUp, stamp.Add(-time.Hour)), events[len(events)-1])
+	assert.Equal(t, EventTypeUp, events[len(events)-2].What)
+}
+
+func TestDaemonBootID(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	first := BootID{1}
+	second := BootID{2}
+	events := func() []Event {
+		events, err := NewDatabaseReader(bytes.NewReader(buff.Bytes())).All()
+		assert.NoError(t, err)
+		return events
+	}
+
+	d := NewDaemonWithClock(store, NewDatabaseWriter(buff), DefaultSleepSeconds*time.Second, clk)
+	d.SetBootID(first)
+	clk.Set(boot.Add(time.Hour))
+	store.getErr = fmt.Errorf("test error")
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	store.getErr = nil
+	assert.Equal(t, first, store.bootID)
+	d.stamp(false)
+
+	// same boot, even though the boot time wobbled far more than the heuristic allows
+	assert.NoError(t, d.Init(boot.Add(10*time.Second), time.Stamp))
+	if all := events(); assert.Len(t, all, 2, "a restart is no outage") {
+		assert.Equal(t, EventTypeMonitorStop, all[0].What)
+		assert.Equal(t, EventTypeMonitorStart, all[1].What)
+	}
+
+	// another boot with the clock behind, which the boot time alone would take for a restart
+	d.SetBootID(second)
+	assert.NoError(t, d.Init(boot.Add(10*time.Second), time.Stamp))
+	if all := events(); assert.Len(t, all, 3) {
+		assert.Equal(t, EventTypeUp, all[2].What)
+		assert.Equal(t, second, all[2].BootID)
+	}
+	assert.Equal(t, second, store.bootID)
+
+	// a regular reboot
+	third := BootID{3}
+	d.SetBootID(third)
+	clk.Set(boot.Add(2 * time.Hour))
+	d.stamp(false)
+	assert.NoError(t, d.Init(boot.Add(2*time.Hour+time.Minute), time.Stamp))
+	if all := events(); assert.Len(t, all, 5) {
+		assert.Equal(t, EventTypeCrash, all[3].What)
+		assert.True(t, all[3].BootID.IsZero())
+		assert.Equal(t, third, all[4].BootID)
+	}
+
+	// a reboot fast enough for the boot time to be the same to the second
+	fourth := BootID{4}
+	d.SetBootID(fourth)
+	clk.Set(boot.Add(2*time.Hour + 30*time.Second))
+	d.stamp(false)
+	assert.NoError(t, d.Init(boot.Add(2*time.Hour+time.Minute), time.Stamp))
+	if all := events(); assert.Len(t, all, 7, "a fast reboot is no restart") {
+		assert.Equal(t, EventTypeCrash, all[5].What)
+		assert.Equal(t, NewEvent(EventTypeUp, boot.Add(2*time.Hour+time.Minute)).When, all[6].When)
+		assert.Equal(t, fourth, all[6].BootID)
+	}
+
+	// without a stored boot ID the boot time decides
+	store.bootID = BootID{}
+	assert.NoError(t, d.Init(boot.Add(2*time.Hour+time.Minute), time.Stamp))
+	if all := events(); assert.Len(t, all, 9, "a restart is no outage") {
+		assert.Equal(t, EventTypeMonitorStart, all[8].What)
+	}
+}
+
+type mockShutdownDetector struct {
+	down bool
+	err  error
+}
+
+func (sd *mockShutdownDetector) ShuttingDown() (bool, error) {
+	return sd.down, sd.err
+}
+
+func TestDaemonMonitorGaps(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	detector := &mockShutdownDetector{}
+	events := func() []Event {
+		events, err := NewDatabaseReader(bytes.NewReader(buff.Bytes())).All()
+		assert.NoError(t, err)
+		return events
+	}
+	run := func(d *Daemon, awake time.Duration) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- d.Run(ctx) }()
+		// let Run stamp before the clock moves
+		time.Sleep(10 * time.Millisecond)
+		clk.Add(awake)
+		cancel()
+		<-done
+	}
+
+	d := NewDaemonWithClock(store, NewDatabaseWriter(buff), time.Hour, clk)
+	d.SetShutdownDetector(detector)
+	clk.Set(boot.Add(time.Minute))
+	store.getErr = fmt.Errorf("test error")
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	store.getErr = nil
+
+	// the daemon is stopped while the system keeps running, and started again later
+	run(d, 10*time.Minute)
+	stop := clk.Now()
+	assert.Equal(t, stop, store.monitorStop)
+	assert.True(t, store.shutdown.IsZero(), "the system did not shut down")
+	clk.Add(time.Hour)
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	assert.Equal(t, []Event{
+		NewEvent(EventTypeMonitorStop, stop),
+		NewEvent(EventTypeMonitorStart, clk.Now()),
+	}, events())
+
+	// the daemon is killed, monitoring stopped at the last stamp
+	d.stamp(false)
+	killed := clk.Now()
+	clk.Add(time.Hour)
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	assert.Equal(t, []Event{
+		NewEvent(EventTypeMonitorStop, killed),
+		NewEvent(EventTypeMonitorStart, clk.Now()),
+	}, events()[2:])
+
+	// the daemon is stopped, then the system shuts down and reboots, which is not a crash at the stop
+	run(d, time.Minute)
+	stop = clk.Now()
+	clk.Add(time.Hour)
+	reboot := clk.Now()
+	clk.Add(time.Minute)
+	assert.NoError(t, d.Init(reboot, time.Stamp))
+	assert.Equal(t, []Event{
+		NewEvent(EventTypeMonitorStop, stop),
+		NewEvent(EventTypeUp, reboot),
+	}, events()[4:])
+	gaps := CoverageGaps(events())
+	if assert.Len(t, gaps, 3) {
+		assert.True(t, gaps[2].Gap())
+		assert.Equal(t, EventTypeUp, gaps[2].Up.What)
+		assert.Equal(t, time.Hour, gaps[2].Duration())
+	}
+
+	// the system shuts down, as the detector can not tell that is assumed
+	detector.err = fmt.Errorf("test error")
+	run(d, time.Minute)
+	shutdown := clk.Now()
+	clk.Add(time.Minute)
+	assert.NoError(t, d.Init(clk.Now(), time.Stamp))
+	assert.Equal(t, []Event{
+		NewEvent(EventTypeShutdown, shutdown),
+		NewEvent(EventTypeUp, clk.Now()),
+	}, events()[6:])
+}
+
+func TestDaemonShutdownKind(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	reason := filepath.Join(t.TempDir(), "reason")
+	power := ShutdownKindUnknown
+	var powerErr error
+
+	d := NewDaemonWithClock(store, NewDatabaseWriter(buff), DefaultSleepSeconds*time.Second, clk)
+	d.SetShutdownKindSource(ShutdownKindSources{
+		ReasonFile(reason),
+		ShutdownKindFunc(func() (ShutdownKind, error) { return power, powerErr }),
+	})
+	clk.Set(boot)
+	store.getErr = fmt.Errorf("test error")
+	assert.NoError(t, d.Init(boot, time.Stamp))
+	store.getErr = nil
+
+	reboot := func() Event {
+		d.stop()
+		boot = clk.Now().Add(time.Minute)
+		clk.Set(boot.Add(time.Minute))
+		assert.NoError(t, d.Init(boot, time.Stamp))
+		return d.LastOutage().Down
+	}
+
+	require.NoError(t, os.WriteFile(reason, []byte("Reboot\n"), 0644))
+	down := reboot()
+	assert.Equal(t, EventTypeShutdown, down.What)
+	assert.Equal(t, ShutdownKindReboot, down.Kind, "the operator's reason comes first")
+
+	require.NoError(t, os.Remove(reason))
+	power = ShutdownKindPowerFailure
+	assert.Equal(t, ShutdownKindPowerFailure, reboot().Kind)
+
+	power, powerErr = ShutdownKindUnknown, fmt.Errorf("test error")
+	assert.Equal(t, ShutdownKindUnknown, reboot().Kind, "the kind of an earlier shutdown is not reused")
+
+	events, err := NewDatabaseReader(bytes.NewReader(buff.Bytes())).All()
+	require.NoError(t, err)
+	if assert.Len(t, events, 6) {
+		assert.Equal(t, ShutdownKindReboot, events[0].Kind)
+		assert.Equal(t, ShutdownKindPowerFailure, events[2].Kind)
+		assert.Equal(t, ShutdownKindUnknown, events[4].Kind)
+		assert.Equal(t, ShutdownKindUnknown, events[1].Kind)
+	}
+
+	require.NoError(t, os.WriteFile(reason, []byte("sideways\n"), 0644))
+	_, err = ReasonFile(reason).ShutdownKind()
+	assert.Error(t, err)
+}
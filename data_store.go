@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -16,6 +17,14 @@ type DataStore interface {
 	GetBoot() (time.Time, error)
 }
 
+// ServiceDataStore is a DataStore that keeps the state of tracked services apart, like DataDir and FileStore.
+type ServiceDataStore interface {
+	DataStore
+	ServiceStore(name string) (DataStore, error)
+}
+
+// NewDataDir opens the data directory dir and locks it, so no other process uses it until Close.
+// If it is in use the error wraps ErrLocked and names the process using it.
 func NewDataDir(dir string) (*DataDir, error) {
 	info, err := os.Stat(dir)
 	if err != nil {
@@ -24,11 +33,44 @@ func NewDataDir(dir string) (*DataDir, error) {
 	if !info.IsDir() {
 		return nil, fmt.Errorf("%s is not a directory", dir)
 	}
-	return &DataDir{dir}, nil
+	lock, err := lockDataDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &DataDir{dir: dir, lock: lock}, nil
 }
 
 type DataDir struct {
 	dir string
+	// lock is held on the directory, the DataDirs of services share the lock of their parent
+	lock *os.File
+}
+
+// Close releases the lock on the directory.
+func (dd DataDir) Close() error {
+	if dd.lock == nil {
+		return nil
+	}
+	return dd.lock.Close()
+}
+
+// Service returns the DataDir keeping the state of a tracked service, a directory below dd that is
+// created if it does not exist.
+func (dd DataDir) Service(name string) (*DataDir, error) {
+	err := ValidateServiceName(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(dd.dir, "services", name)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create datadir of service %s: %w", name, err)
+	}
+	return &DataDir{dir: dir}, nil
+}
+
+func (dd DataDir) ServiceStore(name string) (DataStore, error) {
+	return dd.Service(name)
 }
 
 func (dd DataDir) stampFile() string {
@@ -67,6 +109,50 @@ func (dd DataDir) GetBoot() (time.Time, error) {
 	return stat(dd.bootFile())
 }
 
+func (dd DataDir) bootIDFile() string {
+	return filepath.Join(dd.dir, "downtimed.bootid")
+}
+
+func (dd DataDir) SetBootID(id BootID) error {
+	return os.WriteFile(dd.bootIDFile(), []byte(id.String()+"\n"), 0644)
+}
+
+func (dd DataDir) GetBootID() (BootID, error) {
+	b, err := os.ReadFile(dd.bootIDFile())
+	if err != nil {
+		return BootID{}, err
+	}
+	return ParseBootID(string(b))
+}
+
+func (dd DataDir) shutdownKindFile() string {
+	return filepath.Join(dd.dir, "downtimed.shutdownkind")
+}
+
+func (dd DataDir) SetShutdownKind(kind ShutdownKind) error {
+	return os.WriteFile(dd.shutdownKindFile(), []byte(kind.String()+"\n"), 0644)
+}
+
+func (dd DataDir) GetShutdownKind() (ShutdownKind, error) {
+	b, err := os.ReadFile(dd.shutdownKindFile())
+	if err != nil {
+		return ShutdownKindUnknown, err
+	}
+	return ParseShutdownKind(strings.TrimSpace(string(b)))
+}
+
+func (dd DataDir) monitorStopFile() string {
+	return filepath.Join(dd.dir, "downtimed.monitorstop")
+}
+
+func (dd DataDir) SetMonitorStop(t time.Time) error {
+	return touch(dd.monitorStopFile(), t)
+}
+
+func (dd DataDir) GetMonitorStop() (time.Time, error) {
+	return stat(dd.monitorStopFile())
+}
+
 func touch(name string, t time.Time) error {
 	f, err := os.Create(name)
 	if err != nil {
@@ -1,19 +1,27 @@
 package downtime
 
 import (
-	"encoding/binary"
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 type EventReader interface {
 	io.Closer
 	Next() (Event, error)
+	Prev() (Event, error)
 	Since(after time.Time) ([]Event, error)
+	Between(start, end time.Time) ([]Event, error)
+	Query(q Query) ([]Event, error)
+	Last(n int) ([]Event, error)
 	All() ([]Event, error)
 	Reset() error
+	End() error
 }
 
 type EventWriter interface {
@@ -21,21 +29,193 @@ type EventWriter interface {
 	Append(event Event) error
 }
 
+// NewDatabaseWriter writes a new database to writer, the header is written along with the first event.
+// If writer is a file that is not empty, e.g. an existing database opened for appending, no header is
+// written and Append fails unless the database is in the current format, OpenDatabaseWriter or
+// UpgradeDatabase upgrade older ones.
 func NewDatabaseWriter(writer io.Writer) *DatabaseWriter {
-	return &DatabaseWriter{
-		writer: writer,
+	db := &DatabaseWriter{
+		writer:     writer,
+		format:     currentFormat,
+		needHeader: true,
 	}
+	if f, ok := writer.(interface{ Stat() (os.FileInfo, error) }); ok {
+		info, err := f.Stat()
+		if err == nil && info.Size() > 0 {
+			db.needHeader = false
+			db.err = db.checkFormat()
+		}
+	}
+	return db
+}
+
+// checkFormat makes sure the existing database we append to is in our format, it is read by name
+// since files opened for appending usually are not readable.
+func (db *DatabaseWriter) checkFormat() error {
+	file, ok := db.writer.(interface{ Name() string })
+	if !ok {
+		return nil
+	}
+	r, err := OpenDatabaseReader(file.Name())
+	if err != nil {
+		return fmt.Errorf("unable to check the format of %s: %w", file.Name(), err)
+	}
+	defer r.Close()
+	version, err := r.Version()
+	if err != nil {
+		return fmt.Errorf("unable to append to %s: %w", file.Name(), err)
+	}
+	if version != int(db.format.version) {
+		return fmt.Errorf("%w: unable to append to %s, it is version %d instead of %d, open it with OpenDatabaseWriter or run UpgradeDatabase first",
+			ErrUnsupportedVersion, file.Name(), version, db.format.version)
+	}
+	return nil
+}
+
+// OpenDatabaseWriter opens the database at path for appending, creating it if it does not exist.
+// A torn record left at the end of the file by an interrupted append is discarded,
+// and databases written in an older format are upgraded in place.
+// If the file is replaced, e.g. by RotateDatabase, the writer reopens it before the next append.
+// Only one writer may have the database open, it holds the lock file path.lock until Close and others
+// fail with an error wrapping ErrLocked that names the process holding it.
+func OpenDatabaseWriter(path string) (*DatabaseWriter, error) {
+	lock, err := lockExclusive(path+".lock", path)
+	if err != nil {
+		return nil, err
+	}
+	db := &DatabaseWriter{
+		format:     currentFormat,
+		path:       path,
+		writerLock: lock,
+	}
+	err = db.open()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return db, nil
 }
 
 type DatabaseWriter struct {
-	writer io.Writer
+	writer     io.Writer
+	format     *recordFormat
+	needHeader bool
+	// path is set if we opened the file ourselves
+	path string
+	// writerLock is held on path.lock if we opened the file ourselves
+	writerLock *os.File
+	// err is returned by every Append if the database can not be appended to
+	err error
+}
+
+func (db *DatabaseWriter) open() error {
+	err := realignDatabase(db.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	_, err = UpgradeDatabase(db.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	file, err := os.OpenFile(db.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	db.writer = file
+	db.needHeader = info.Size() == 0
+	return nil
+}
+
+// replaced reports whether the file at path is no longer the one we have open
+func (db *DatabaseWriter) replaced() (bool, error) {
+	file, ok := db.writer.(*os.File)
+	if db.path == "" || !ok {
+		return false, nil
+	}
+	current, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(db.path)
+	if err == nil && os.SameFile(current, info) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+// reopen opens the file at path again if it is no longer the one we have open
+func (db *DatabaseWriter) reopen() error {
+	replaced, err := db.replaced()
+	if err != nil || !replaced {
+		return err
+	}
+	logger.Infof("%s was replaced, reopening", db.path)
+	db.writer.(*os.File).Close()
+	return db.open()
+}
+
+/*
+lock takes the exclusive lock on the file we opened for an append. Readers take a shared lock for every
+read, so they never see half of a record. Rewrites like RotateDatabase hold the exclusive lock until the
+file is replaced, so if it was replaced once we have the lock, the new file is locked instead.
+*/
+func (db *DatabaseWriter) lock() (unlock func(), err error) {
+	for {
+		err = db.reopen()
+		if err != nil {
+			return nil, err
+		}
+		file, ok := db.writer.(*os.File)
+		if db.path == "" || !ok {
+			return func() {}, nil
+		}
+		err = lockFile(file, true)
+		if err != nil {
+			return nil, fmt.Errorf("unable to lock %s: %w", db.path, err)
+		}
+		replaced, err := db.replaced()
+		if err == nil && !replaced {
+			return func() { unlockFile(file) }, nil
+		}
+		unlockFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
 }
 
 func (db *DatabaseWriter) Append(event Event) error {
-	err := binary.Write(db.writer, binary.BigEndian, event)
+	if event.Service != "" {
+		err := ValidateServiceName(event.Service)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		}
+	}
+	if db.err != nil {
+		return db.err
+	}
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	record := db.format.encode(event)
+	if db.needHeader {
+		record = append(newHeader(db.format).encode(), record...)
+	}
+	_, err = db.writer.Write(record)
 	if err != nil {
 		return err
 	}
+	db.needHeader = false
 	sync, ok := db.writer.(syncer)
 	if ok {
 		return sync.Sync()
@@ -44,66 +224,281 @@ func (db *DatabaseWriter) Append(event Event) error {
 }
 
 func (db *DatabaseWriter) Close() error {
+	var err error
 	c, ok := db.writer.(io.Closer)
 	if ok {
-		return c.Close()
+		err = c.Close()
 	}
-	return nil
+	if db.writerLock != nil {
+		db.writerLock.Close()
+	}
+	return err
 }
 
 type DatabaseReader struct {
 	reader io.ReadSeeker
+	format *recordFormat
+	// offset of the first record
+	offset int64
+
+	skipCorrupt bool
+	onCorrupt   func(*RecordError)
 }
 
+// NewDatabaseReader reads a database of any supported version, the format is detected on first use.
 func NewDatabaseReader(reader io.ReadSeeker) *DatabaseReader {
 	return &DatabaseReader{
 		reader: reader,
 	}
 }
 
+// OpenDatabaseReader opens the database at filepath, every read takes a shared lock on the file so
+// it waits for an append by a DatabaseWriter to finish.
 func OpenDatabaseReader(filepath string) (*DatabaseReader, error) {
 	file, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
 	}
-	return NewDatabaseReader(file), nil
+	return NewDatabaseReader(sharedFile{file}), nil
+}
+
+// detect reads the header, if any, and leaves the reader positioned at the first record
+func (db *DatabaseReader) detect() error {
+	if db.format != nil {
+		return nil
+	}
+	_, err := db.reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, HeaderSize)
+	n, err := io.ReadFull(db.reader, buf)
+	switch {
+	case n == 0 && errors.Is(err, io.EOF):
+		// empty database, nothing to detect
+		db.format = currentFormat
+		db.offset = 0
+		return nil
+	case err != nil && !errors.Is(err, io.ErrUnexpectedEOF):
+		return err
+	}
+
+	h, err := decodeHeader(buf[:n])
+	if err == nil {
+		format, err := lookupFormat(h)
+		if err != nil {
+			return err
+		}
+		db.format = format
+		db.offset = HeaderSize
+		return nil
+	}
+
+	// no header, it is either a legacy database or garbage
+	if n < legacyFormat.size {
+		return ErrNotDatabase
+	}
+	_, err = legacyFormat.decode(buf[:legacyFormat.size])
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotDatabase, err)
+	}
+	db.format = legacyFormat
+	db.offset = 0
+	_, err = db.reader.Seek(db.offset, io.SeekStart)
+	return err
+}
+
+// SkipCorrupt makes the reader skip records that are corrupt or truncated instead of failing,
+// each skipped record is passed to report if it is not nil.
+func (db *DatabaseReader) SkipCorrupt(report func(*RecordError)) {
+	db.skipCorrupt = true
+	db.onCorrupt = report
+}
+
+func (db *DatabaseReader) corrupt(err *RecordError) {
+	if db.onCorrupt != nil {
+		db.onCorrupt(err)
+	}
+}
+
+// Version reports the format version of the database.
+func (db *DatabaseReader) Version() (int, error) {
+	err := db.detect()
+	if err != nil {
+		return 0, err
+	}
+	return int(db.format.version), nil
+}
+
+// Count returns the number of records in the database.
+func (db *DatabaseReader) Count() (int64, error) {
+	err := db.detect()
+	if err != nil {
+		return 0, err
+	}
+	pos, err := db.reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := db.reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.reader.Seek(pos, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	size := end - db.offset
+	if size < 0 {
+		size = 0
+	}
+	if size%db.format.size64() != 0 && !db.skipCorrupt {
+		return 0, fmt.Errorf("database size is invalid")
+	}
+	return size / db.format.size64(), nil
+}
+
+// SeekRecord positions the reader so the next call to Next returns the record at index.
+func (db *DatabaseReader) SeekRecord(index int64) error {
+	err := db.detect()
+	if err != nil {
+		return err
+	}
+	_, err = db.reader.Seek(db.offset+index*db.format.size64(), io.SeekStart)
+	return err
 }
 
 func (db *DatabaseReader) Next() (Event, error) {
-	var event Event
-	err := binary.Read(db.reader, binary.BigEndian, &event)
-	return event, err
+	err := db.detect()
+	if err != nil {
+		return Event{}, err
+	}
+	buf := make([]byte, db.format.size)
+	for {
+		offset, err := db.reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return Event{}, err
+		}
+		n, err := io.ReadFull(db.reader, buf)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			recErr := &RecordError{Offset: offset, Err: fmt.Errorf("%w: %d of %d bytes", ErrTruncated, n, db.format.size)}
+			if !db.skipCorrupt {
+				return Event{}, recErr
+			}
+			db.corrupt(recErr)
+			return Event{}, io.EOF
+		}
+		if err != nil {
+			return Event{}, err
+		}
+		event, err := db.format.decode(buf)
+		if err != nil {
+			recErr := &RecordError{Offset: offset, Err: err}
+			if !db.skipCorrupt {
+				return Event{}, recErr
+			}
+			db.corrupt(recErr)
+			continue
+		}
+		return event, nil
+	}
 }
 
-func (db *DatabaseReader) Since(after time.Time) ([]Event, error) {
+// Prev returns the record before the current position and moves the reader back to it,
+// after End successive calls walk the database from the last record to the first.
+func (db *DatabaseReader) Prev() (Event, error) {
+	err := db.detect()
+	if err != nil {
+		return Event{}, err
+	}
+	buf := make([]byte, db.format.size)
+	for {
+		pos, err := db.reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return Event{}, err
+		}
+		// stay aligned even if we were left in the middle of a torn record
+		offset := pos - db.format.size64()
+		if offset < db.offset {
+			return Event{}, io.EOF
+		}
+		offset -= (offset - db.offset) % db.format.size64()
+		_, err = db.reader.Seek(offset, io.SeekStart)
+		if err != nil {
+			return Event{}, err
+		}
+		_, err = io.ReadFull(db.reader, buf)
+		if err != nil {
+			return Event{}, err
+		}
+		_, err = db.reader.Seek(offset, io.SeekStart)
+		if err != nil {
+			return Event{}, err
+		}
+		event, err := db.format.decode(buf)
+		if err != nil {
+			recErr := &RecordError{Offset: offset, Err: err}
+			if !db.skipCorrupt {
+				return Event{}, recErr
+			}
+			db.corrupt(recErr)
+			continue
+		}
+		return event, nil
+	}
+}
+
+// Last returns the last n events of the database in the order they were written.
+func (db *DatabaseReader) Last(n int) ([]Event, error) {
 	events := []Event{}
-	err := db.Reset()
+	err := db.End()
 	if err != nil {
 		return events, err
 	}
-	for {
-		e, err := db.Next()
+	for len(events) < n {
+		e, err := db.Prev()
 		if errors.Is(err, io.EOF) {
-			return events, nil
+			break
 		}
 		if err != nil {
 			return events, err
 		}
-		if e.When.AsTime().After(after) {
-			events = append(events, e)
-		}
+		events = append(events, e)
+	}
+	reverseEvents(events)
+	return events, nil
+}
+
+// Since returns the events after the given time.
+func (db *DatabaseReader) Since(after time.Time) ([]Event, error) {
+	if after.IsZero() {
+		return db.All()
 	}
+	return db.Query(Query{Since: after.Add(time.Nanosecond)})
 }
 
 func (db *DatabaseReader) All() ([]Event, error) {
-	return db.Since(time.Time{})
+	return db.Query(Query{})
 }
 
 func (db *DatabaseReader) Reset() error {
-	_, err := db.reader.Seek(0, io.SeekStart)
+	err := db.detect()
+	if err != nil {
+		return err
+	}
+	_, err = db.reader.Seek(db.offset, io.SeekStart)
 	return err
 }
 
+// End positions the reader after the last complete record.
+func (db *DatabaseReader) End() error {
+	count, err := db.Count()
+	if err != nil {
+		return err
+	}
+	return db.SeekRecord(count)
+}
+
 func (db *DatabaseReader) Close() error {
 	c, ok := db.reader.(io.Closer)
 	if ok {
@@ -112,6 +507,168 @@ func (db *DatabaseReader) Close() error {
 	return nil
 }
 
+// UpgradeDatabase rewrites the database at path in the current format if it was written by an older version.
+// The new database is written next to the old one and renamed over it, so the upgrade is atomic.
+func UpgradeDatabase(path string) (upgraded bool, err error) {
+	var version int
+	err = lockedRewrite(path, func(r *DatabaseReader) ([]Event, error) {
+		version, err = r.Version()
+		if err != nil || version == DatabaseVersion {
+			return nil, err
+		}
+		r.SkipCorrupt(func(err *RecordError) {
+			logger.Warningf("dropping unreadable %s while upgrading %s", err, path)
+		})
+		return r.All()
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to upgrade %s: %w", path, err)
+	}
+	if version == DatabaseVersion {
+		return false, nil
+	}
+	logger.Infof("upgraded %s from version %d to %d", path, version, DatabaseVersion)
+	return true, nil
+}
+
+// realignDatabase truncates a partially written record from the end of the database at path
+func realignDatabase(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = lockFile(file, true)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil
+	}
+
+	if size < HeaderSize {
+		head := make([]byte, size)
+		_, err = io.ReadFull(file, head)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix([]byte(DatabaseMagic), head) || bytes.HasPrefix(head, []byte(DatabaseMagic)) {
+			// the very first append was interrupted
+			logger.Warningf("discarding torn header of %s", path)
+			return truncate(file, 0)
+		}
+	}
+
+	r := NewDatabaseReader(file)
+	err = r.detect()
+	if err != nil {
+		return fmt.Errorf("unable to realign %s: %w", path, err)
+	}
+	torn := (size - r.offset) % r.format.size64()
+	if torn == 0 {
+		return nil
+	}
+	logger.Warningf("discarding %d bytes of torn record at offset %d of %s", torn, size-torn, path)
+	return truncate(file, size-torn)
+}
+
+func truncate(file *os.File, size int64) error {
+	err := file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+/*
+rewriteDatabase replaces the database at path with the events rewrite returns for the events in it,
+nothing is written if it returns nil. The file is locked exclusively from reading it until it is replaced,
+so a DatabaseWriter appending meanwhile waits and then appends to the new file instead of losing events.
+*/
+func rewriteDatabase(path string, onCorrupt func(*RecordError), rewrite func(events []Event) ([]Event, error)) error {
+	return lockedRewrite(path, func(r *DatabaseReader) ([]Event, error) {
+		r.SkipCorrupt(onCorrupt)
+		events, err := r.All()
+		if err != nil {
+			return nil, err
+		}
+		return rewrite(events)
+	})
+}
+
+// lockedRewrite is rewriteDatabase for callers that need the reader itself, e.g. to check the version
+func lockedRewrite(path string, rewrite func(r *DatabaseReader) ([]Event, error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = lockFile(file, true)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", path, err)
+	}
+	events, err := rewrite(NewDatabaseReader(file))
+	if err != nil || events == nil {
+		return err
+	}
+	return WriteDatabase(path, events)
+}
+
+// WriteDatabase atomically replaces the database at path with a new one holding events.
+func WriteDatabase(path string, events []Event) error {
+	mode := os.FileMode(0666)
+	info, err := os.Stat(path)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// buffer the writes so we only sync once at the end
+	buf := bufio.NewWriter(tmp)
+	w := NewDatabaseWriter(buf)
+	for _, event := range events {
+		err = w.Append(event)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	err = buf.Flush()
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func reverseEvents(events []Event) {
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+}
+
 type syncer interface {
 	Sync() error
 }
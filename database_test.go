@@ -2,11 +2,14 @@ package downtime_test
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/abferm/downtime"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReaderSince(t *testing.T) {
@@ -60,3 +63,304 @@ func TestWriterAppend(t *testing.T) {
 
 	assert.Equal(t, expectedEvents, actualEvents)
 }
+
+func TestWriterAppendsToOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downtimedb")
+	expectedEvents := []downtime.Event{}
+	for i := int64(0); i < 3; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		require.NoError(t, err)
+		event := downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633484567+i, 0))
+		require.NoError(t, downtime.NewDatabaseWriter(f).Append(event))
+		require.NoError(t, f.Close())
+		expectedEvents = append(expectedEvents, event)
+	}
+
+	r, err := downtime.OpenDatabaseReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	events, err := r.All()
+	require.NoError(t, err)
+	assert.Equal(t, expectedEvents, events, "the header is only written once")
+}
+
+func TestWriterRejectsOldDatabase(t *testing.T) {
+	legacy, err := os.ReadFile("./test.db")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	require.NoError(t, os.WriteFile(path, legacy, 0644))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	defer f.Close()
+	err = downtime.NewDatabaseWriter(f).Append(downtime.NewEvent(downtime.EventTypeUp, time.Unix(1646000000, 0)))
+	assert.ErrorIs(t, err, downtime.ErrUnsupportedVersion)
+	assert.Contains(t, err.Error(), "UpgradeDatabase")
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, b, "nothing was appended")
+	r, err := downtime.OpenDatabaseReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = r.All()
+	assert.NoError(t, err)
+}
+
+func TestReaderVersion(t *testing.T) {
+	legacy, err := downtime.OpenDatabaseReader("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+
+	version, err := legacy.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, downtime.LegacyVersion, version)
+
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	err = w.Append(downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633484567, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte(downtime.DatabaseMagic), buff.Bytes()[:len(downtime.DatabaseMagic)])
+
+	current := downtime.NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	version, err = current.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, downtime.DatabaseVersion, version)
+
+	count, err := current.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestReaderRejectsGarbage(t *testing.T) {
+	garbage := bytes.Repeat([]byte("not a database!!"), 4)
+	r := downtime.NewDatabaseReader(bytes.NewReader(garbage))
+	_, err := r.All()
+	assert.ErrorIs(t, err, downtime.ErrNotDatabase)
+
+	future := append([]byte(downtime.DatabaseMagic), 0xff, 0xff, 0, 16)
+	future = append(future, make([]byte, 8)...)
+	r = downtime.NewDatabaseReader(bytes.NewReader(future))
+	_, err = r.All()
+	assert.ErrorIs(t, err, downtime.ErrUnsupportedVersion)
+}
+
+func TestUpgradeDatabase(t *testing.T) {
+	legacy, err := os.ReadFile("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	err = os.WriteFile(path, legacy, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := downtime.NewDatabaseReader(bytes.NewReader(legacy))
+	expectedEvents, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded, err := downtime.UpgradeDatabase(path)
+	assert.NoError(t, err)
+	assert.True(t, upgraded)
+
+	upgraded, err = downtime.UpgradeDatabase(path)
+	assert.NoError(t, err)
+	assert.False(t, upgraded, "database is already current")
+
+	w, err := downtime.OpenDatabaseWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1646000000, 0))
+	assert.NoError(t, w.Append(extra))
+	assert.NoError(t, w.Close())
+
+	r2, err := downtime.OpenDatabaseReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	version, err := r2.Version()
+	assert.NoError(t, err)
+	assert.Equal(t, downtime.DatabaseVersion, version)
+
+	actualEvents, err := r2.All()
+	assert.NoError(t, err)
+	assert.Equal(t, append(expectedEvents, extra), actualEvents)
+}
+
+func TestSubSecondPrecision(t *testing.T) {
+	down := time.Unix(1633484567, 900000000)
+	up := time.Unix(1633484568, 100000123)
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	assert.NoError(t, w.Append(downtime.NewEvent(downtime.EventTypeCrash, down)))
+	assert.NoError(t, w.Append(downtime.NewEvent(downtime.EventTypeUp, up)))
+
+	r := downtime.NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	events, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, events, 2)
+	assert.True(t, events[0].When.AsTime().Equal(down))
+	assert.Equal(t, 200000123*time.Nanosecond, events[1].When.AsTime().Sub(events[0].When.AsTime()))
+}
+
+func TestReaderCorruptRecords(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	expectedEvents := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633484567, 0)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633484568, 0)),
+		downtime.NewEvent(downtime.EventTypeShutdown, time.Unix(1633484569, 0)),
+	}
+	for _, event := range expectedEvents {
+		assert.NoError(t, w.Append(event))
+	}
+	data := buff.Bytes()
+	// flip a bit in the second record and leave half a record at the end
+	data[downtime.HeaderSize+downtime.EventSize+12] ^= 0x01
+	data = append(data, data[downtime.HeaderSize:downtime.HeaderSize+downtime.EventSize/2]...)
+
+	strict := downtime.NewDatabaseReader(bytes.NewReader(data))
+	_, err := strict.All()
+	var recErr *downtime.RecordError
+	if assert.ErrorAs(t, err, &recErr) {
+		assert.Equal(t, int64(downtime.HeaderSize+downtime.EventSize), recErr.Offset)
+		assert.ErrorIs(t, err, downtime.ErrChecksum)
+	}
+
+	tolerant := downtime.NewDatabaseReader(bytes.NewReader(data))
+	skipped := []*downtime.RecordError{}
+	tolerant.SkipCorrupt(func(err *downtime.RecordError) {
+		skipped = append(skipped, err)
+	})
+	events, err := tolerant.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{expectedEvents[0], expectedEvents[2]}, events)
+	if assert.Len(t, skipped, 2) {
+		assert.ErrorIs(t, skipped[0], downtime.ErrChecksum)
+		assert.ErrorIs(t, skipped[1], downtime.ErrTruncated)
+		assert.Equal(t, int64(downtime.HeaderSize+downtime.EventSize*3), skipped[1].Offset)
+	}
+}
+
+func TestWriterRealignsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	w, err := downtime.OpenDatabaseWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633484567, 0))
+	assert.NoError(t, w.Append(first))
+	assert.NoError(t, w.Close())
+
+	// simulate a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte{byte(downtime.EventTypeUp), 0, 0})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	w, err = downtime.OpenDatabaseWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633484568, 0))
+	assert.NoError(t, w.Append(second))
+	assert.NoError(t, w.Close())
+
+	r, err := downtime.OpenDatabaseReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	events, err := r.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{first, second}, events)
+}
+
+func TestReaderQuery(t *testing.T) {
+	db, err := downtime.OpenDatabaseReader("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	all, err := db.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := time.Unix(1629816062, 0), time.Unix(1629852505, 0)
+	expected := []downtime.Event{}
+	for _, event := range all {
+		when := event.When.AsTime()
+		if !when.Before(start) && when.Before(end) {
+			expected = append(expected, event)
+		}
+	}
+
+	between, err := db.Between(start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, between)
+
+	crashes, err := db.Query(downtime.Query{Types: []downtime.EventType{downtime.EventTypeCrash}, Limit: 2, Descending: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{all[62], all[54]}, crashes)
+
+	latest, err := db.Query(downtime.Query{Since: start, Limit: 3, Descending: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{all[75], all[74], all[73]}, latest)
+}
+
+func TestReaderQueryOutOfOrder(t *testing.T) {
+	at := func(sec int64) downtime.Event { return downtime.NewEvent(downtime.EventTypeUp, time.Unix(sec, 0)) }
+	// a clock that was stepped backwards leaves records out of order
+	events := []downtime.Event{at(10), at(30), at(20), at(40), at(50), at(60), at(70), at(80)}
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	for _, event := range events {
+		assert.NoError(t, w.Append(event))
+	}
+
+	r := downtime.NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	found, err := r.Between(time.Unix(15, 0), time.Unix(35, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{at(30), at(20)}, found)
+}
+
+func TestReaderQueryCorruptTail(t *testing.T) {
+	at := func(sec int64) downtime.Event { return downtime.NewEvent(downtime.EventTypeUp, time.Unix(sec, 0)) }
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	events := []downtime.Event{}
+	for i := int64(0); i < 10; i++ {
+		events = append(events, at(i*10))
+		assert.NoError(t, w.Append(events[i]))
+	}
+	b := buff.Bytes()
+	// flip the checksum of the last record
+	b[len(b)-downtime.EventSize+4] ^= 0xff
+
+	r := downtime.NewDatabaseReader(bytes.NewReader(b))
+	var skipped []*downtime.RecordError
+	r.SkipCorrupt(func(err *downtime.RecordError) { skipped = append(skipped, err) })
+	found, err := r.Query(downtime.Query{Since: time.Unix(35, 0)})
+	assert.NoError(t, err)
+	assert.Equal(t, events[4:9], found)
+	assert.NotEmpty(t, skipped)
+
+	found, err = r.Query(downtime.Query{Since: time.Unix(95, 0)})
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
@@ -5,12 +5,18 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log/syslog"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -31,11 +37,27 @@ func main() {
 func execute() error {
 	noDB := flag.Bool("D", false, "Do not create nor update the downtime database.")
 	dataDir := flag.String("d", downtime.DefaultDataDir, "The directory where the time stamp files as well as the downtime database are located.")
+	storeKind := flag.String("store", "dir", "How to keep the time stamps in -d, either \"dir\" in the times of empty files, or \"file\" in "+downtime.DefaultStateFile+", a single checksummed file which keeps nanoseconds on any file system. The state kept by \"dir\" is taken over by \"file\".")
 	noFork := flag.Bool("F", false, "Do not call daemon(3) to fork(2) to background. Useful with modern system service managers such as systemd(8), launchd(8) and others.")
 	cTimeFormat := flag.String("f", downtime.DefaultTimeFormat, "Specify the time and date format to use when reporting using strftime(3) syntax.")
+	listen := flag.String("listen", "", "Serve the daemon status and the recorded outages as JSON, and Prometheus metrics on /metrics, over HTTP on this address, e.g. 127.0.0.1:9736. Default is not to.")
+	textfileDir := flag.String("textfile-dir", "", "Write Prometheus metrics to "+textfileName+" in this directory after startup and on every update of the time stamp, for the textfile collector of node_exporter. Default is not to.")
 	logDestination := flag.String("l", "daemon", "Logging destination. If the argument contains a slash (/) it is interpreted to be a path name to a log file, which will be created if it does not exist already. Otherwise it is interpreted as a syslog facility name.")
 	pidFile := flag.String("p", "/var/run/downtimed.pid", "The location of the file which keeps track of the process ID of the running daemon process. The system default location is determined at compile time. May be disabled by specifying \"none\".")
 	flag.Bool("S", false, "Disable fsync (ignored)")
+	rotateDays := flag.Int("rotate", 0, "On startup move events older than this many days from the downtime database into a compressed archive next to it. Default is to never rotate.")
+	var outputs outputFlags
+	flag.Var(&outputs, "o", "Also record events in the downtime database at this path, e.g. on another disk. Append \",best-effort\" to only log failures or \",retry\" to queue events until the database can be written again, by default failures are fatal like those of the main database. May be given several times.")
+	reasonFile := flag.String("reason-file", "", "Read the kind of shutdown, i.e. reboot, poweroff, halt or power-failure, from this file when the system goes down, e.g. /run/downtimed.reason. Otherwise it is a power failure if SIGPWR was received, or whatever systemd is doing.")
+	var services serviceFlags
+	flag.Var(&services, "service", "Also track the downtime of a service, given as \"name=pidfile\". It is up while the process in the PID file runs, its state is kept in the services directory of -d and its events are recorded in the same databases, tagged with the name. May be given several times.")
+	wtmpFile := flag.String("w", "", "Also record crashes in this wtmp(5) file, e.g. "+downtime.DefaultWtmpFile+", so they are listed by last -x. Default is not to.")
+	onCrash := flag.String("on-crash", "", "Run this command with /bin/sh after a crash was detected. The outage is described by the DOWNTIME_EVENT, DOWNTIME_DOWN, DOWNTIME_UP, DOWNTIME_UPTIME and DOWNTIME_DOWNTIME environment variables.")
+	pstoreDir := flag.String("pstore", downtime.DefaultPstoreDir, "After a crash archive the records the kernel left in this pstore directory in the crashes directory of -d, and show what went wrong in downtimes(1). May be disabled by specifying \"none\".")
+	onShutdown := flag.String("on-shutdown", "", "Run this command with /bin/sh after a shutdown was detected, like -on-crash.")
+	onUp := flag.String("on-up", "", "Run this command with /bin/sh after every outage, whether it was a crash, a shutdown or DOWNTIME_EVENT is unknown, e.g. because monitoring was stopped, like -on-crash.")
+	hookTimeout := flag.Int("hook-timeout", int(downtime.DefaultHookTimeout/time.Second), "Kill commands run by -on-crash, -on-shutdown and -on-up after this many seconds.")
+	retainDays := flag.Int("retain", 0, "On startup delete archives whose newest event is older than this many days. Default is to keep them forever.")
 	sleep := flag.Int64("s", downtime.DefaultSleepSeconds, "Defines how long to sleep between each update of the on−disk time stamp file. More frequent updates result in more accurate downtime reporting in the case of a system crash. Less frequent updates decrease the amount of disk writes performed.")
 	version := flag.Bool("v", false, "Display the program version number and copyright message.")
 	flag.Parse()
@@ -83,32 +105,135 @@ func execute() error {
 	}
 	loggo.ReplaceDefaultWriter(loggocolor.NewColorWriter(logDest))
 
-	store, err := downtime.NewDataDir(*dataDir)
+	store, err := openStore(*storeKind, *dataDir)
 	if err != nil {
 		logger.Criticalf(err.Error())
 		return err
 	}
+	defer store.Close()
 
-	var dbWriter io.Writer
+	var db *downtime.DatabaseWriter
 	if *noDB {
-		dbWriter = bytes.NewBuffer([]byte{})
+		db = downtime.NewDatabaseWriter(bytes.NewBuffer([]byte{}))
 	} else {
-		var err error
-		dbWriter, err = os.OpenFile(filepath.Join(*dataDir, downtime.DefaultDBFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		dbPath := filepath.Join(*dataDir, downtime.DefaultDBFile)
+		err := maintainDatabase(dbPath, *rotateDays, *retainDays)
+		if err != nil {
+			logger.Errorf("database maintenance failed: %s", err.Error())
+		}
+		db, err = downtime.OpenDatabaseWriter(dbPath)
 		if err != nil {
 			logger.Criticalf("could not open downtimedb: %s", err.Error())
 			return err
 		}
 	}
+	sinks := []downtime.Sink{{Name: "downtimedb", Writer: db, Policy: downtime.SinkRequired}}
+	for _, output := range outputs {
+		var w downtime.EventWriter
+		db, err := downtime.OpenDatabaseWriter(output.path)
+		if err == nil {
+			w = db
+		} else {
+			// only a required database has to be there at startup
+			switch output.policy {
+			case downtime.SinkBestEffort:
+				logger.Errorf("could not open %s, not recording there: %s", output.path, err.Error())
+				continue
+			case downtime.SinkRetry:
+				logger.Errorf("could not open %s, retrying: %s", output.path, err.Error())
+				w = downtime.NewLazyDatabaseWriter(output.path)
+			default:
+				logger.Criticalf("could not open %s: %s", output.path, err.Error())
+				return err
+			}
+		}
+		sinks = append(sinks, downtime.Sink{Name: output.path, Writer: w, Policy: output.policy})
+	}
+	if *wtmpFile != "" {
+		wtmp, err := downtime.OpenWtmpWriter(*wtmpFile)
+		if err != nil {
+			logger.Criticalf("could not open %s: %s", *wtmpFile, err.Error())
+			return err
+		}
+		sinks = append(sinks, downtime.Sink{Name: *wtmpFile, Writer: wtmp, Policy: downtime.SinkBestEffort})
+	}
+	var metrics *downtime.Metrics
+	if *listen != "" || *textfileDir != "" {
+		metrics = downtime.NewMetrics()
+		if !*noDB {
+			err := loadMetrics(metrics, filepath.Join(*dataDir, downtime.DefaultDBFile))
+			if err != nil {
+				logger.Errorf("could not load metrics: %s", err.Error())
+			}
+		}
+		sinks = append(sinks, downtime.Sink{Name: "metrics", Writer: metrics, Policy: downtime.SinkBestEffort})
+	}
+	events := downtime.NewFanOutWriter(sinks...)
+	defer events.Close()
 
-	db := downtime.NewDatabaseWriter(dbWriter)
-	defer db.Close()
-
-	daemon := downtime.NewDaemon(store, db, time.Duration(*sleep)*time.Second)
+	daemon := downtime.NewDaemon(store, events, time.Duration(*sleep)*time.Second)
+	bootID, err := downtime.SystemBootID()
+	if err != nil {
+		logger.Warningf("%s, telling reboots from restarts by the boot time", err.Error())
+	} else {
+		daemon.SetBootID(bootID)
+	}
+	shutdownDetector := downtime.SystemShutdownDetector()
+	daemon.SetShutdownDetector(shutdownDetector)
+	kinds := downtime.ShutdownKindSources{}
+	if *reasonFile != "" {
+		kinds = append(kinds, downtime.ReasonFile(*reasonFile))
+	}
+	kinds = append(kinds, watchPowerFailure(), downtime.SystemdShutdownKind())
+	daemon.SetShutdownKindSource(kinds)
+	if *pstoreDir != "none" {
+		daemon.AddHook(downtime.EventTypeCrash, downtime.NewCrashArchive(*dataDir).PstoreHook(*pstoreDir))
+	}
+	if *onCrash != "" {
+		daemon.AddHook(downtime.EventTypeCrash, downtime.NewExecHook(*onCrash, time.Duration(*hookTimeout)*time.Second))
+	}
+	if *onShutdown != "" {
+		daemon.AddHook(downtime.EventTypeShutdown, downtime.NewExecHook(*onShutdown, time.Duration(*hookTimeout)*time.Second))
+	}
+	if *onUp != "" {
+		daemon.AddHook(downtime.EventTypeUp, downtime.NewExecHook(*onUp, time.Duration(*hookTimeout)*time.Second))
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if metrics != nil {
+		defer metrics.Watch(daemon)()
+	}
+	var textfile string
+	if *textfileDir != "" {
+		textfile = filepath.Join(*textfileDir, textfileName)
+		defer daemon.Subscribe(func(n downtime.Notification) {
+			if n.Kind == downtime.NotifyStamp {
+				writeTextfile(metrics, textfile)
+			}
+		})()
+	}
+	if *listen != "" {
+		dbPath := filepath.Join(*dataDir, downtime.DefaultDBFile)
+		mux := http.NewServeMux()
+		mux.Handle("/", downtime.NewStatusHandler(daemon, dbPath))
+		mux.Handle("/metrics", metrics)
+		server := &http.Server{Addr: *listen, Handler: mux}
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			logger.Criticalf("could not listen on %s: %s", *listen, err.Error())
+			return err
+		}
+		go func() {
+			err := server.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorf("status server failed: %s", err.Error())
+			}
+		}()
+		defer server.Close()
+	}
+
 	boottime, err := downtime.SystemBootTime()
 	if err != nil {
 		logger.Criticalf(err.Error())
@@ -124,6 +249,31 @@ func execute() error {
 		logger.Criticalf("init failed: %s", err.Error())
 		return err
 	}
+	if textfile != "" {
+		writeTextfile(metrics, textfile)
+	}
+
+	if len(services) > 0 {
+		tracker := downtime.NewMultiTracker(store, events, time.Duration(*sleep)*time.Second)
+		for _, service := range services {
+			d, err := tracker.Track(service.name, pidFileStartTime(service.pidFile))
+			if err != nil {
+				logger.Criticalf("can not track %s: %s", service.name, err.Error())
+				return err
+			}
+			d.SetShutdownDetector(shutdownDetector)
+			d.SetShutdownKindSource(kinds)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tracker.Run(ctx, goTimeFormat)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
 
 	err = daemon.Run(ctx)
 	if errors.Is(err, context.Canceled) {
@@ -132,3 +282,179 @@ func execute() error {
 	logger.Criticalf(err.Error())
 	return err
 }
+
+// dataStore is a DataDir or FileStore, which lock the data directory until closed
+type dataStore interface {
+	downtime.ServiceDataStore
+	io.Closer
+}
+
+// openStore opens the data store of the given kind in dir
+func openStore(kind, dir string) (dataStore, error) {
+	dd, err := downtime.NewDataDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "dir":
+		return dd, nil
+	case "file":
+		// the FileStore takes over the lock, the state of the DataDir can still be read
+		fs, err := dd.FileStore()
+		if err != nil {
+			dd.Close()
+			return nil, err
+		}
+		migrated, err := downtime.MigrateDataDir(dd, fs)
+		if err != nil {
+			fs.Close()
+			return nil, err
+		}
+		if migrated {
+			logger.Infof("migrated the time stamps in %s to %s", dir, downtime.DefaultStateFile)
+		}
+		return fs, nil
+	}
+	dd.Close()
+	return nil, fmt.Errorf("unknown store %q, expected dir or file", kind)
+}
+
+// textfileName is the file written to -textfile-dir, the collector only reads files ending in .prom
+const textfileName = "downtimed.prom"
+
+func writeTextfile(metrics *downtime.Metrics, path string) {
+	err := metrics.WriteTextfile(path)
+	if err != nil {
+		logger.Errorf("could not write %s: %s", path, err.Error())
+	}
+}
+
+// loadMetrics reads the existing history of the database and its archives into metrics
+func loadMetrics(metrics *downtime.Metrics, dbPath string) error {
+	db, err := downtime.OpenDatabaseSegments(dbPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return metrics.Load(db)
+}
+
+// maintainDatabase rotates old events into archives and deletes expired archives
+func maintainDatabase(dbPath string, rotateDays, retainDays int) error {
+	const day = 24 * time.Hour
+	now := time.Now()
+	if rotateDays > 0 {
+		_, err := downtime.RotateDatabase(dbPath, now.Add(-time.Duration(rotateDays)*day))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if retainDays > 0 {
+		_, err := downtime.PruneArchives(dbPath, now.Add(-time.Duration(retainDays)*day))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchPowerFailure returns a source of the shutdown kind that is a power failure once one was signalled
+func watchPowerFailure() downtime.ShutdownKindSource {
+	var failed int32
+	signals := make(chan os.Signal, 1)
+	if len(powerFailureSignals) > 0 {
+		signal.Notify(signals, powerFailureSignals...)
+	}
+	go func() {
+		for sig := range signals {
+			logger.Warningf("received %s, the power failed", sig)
+			atomic.StoreInt32(&failed, 1)
+		}
+	}()
+	return downtime.ShutdownKindFunc(func() (downtime.ShutdownKind, error) {
+		if atomic.LoadInt32(&failed) != 0 {
+			return downtime.ShutdownKindPowerFailure, nil
+		}
+		return downtime.ShutdownKindUnknown, nil
+	})
+}
+
+// pidFileStartTime returns when the process in the PID file at path started
+func pidFileStartTime(path string) func() (time.Time, error) {
+	return func() (time.Time, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid PID file %s: %w", path, err)
+		}
+		return downtime.ProcessStartTime(pid)
+	}
+}
+
+type service struct {
+	name    string
+	pidFile string
+}
+
+// serviceFlags collects the -service flags, each is a service name, an equals sign and a PID file
+type serviceFlags []service
+
+func (s *serviceFlags) String() string {
+	services := make([]string, len(*s))
+	for i, service := range *s {
+		services[i] = service.name + "=" + service.pidFile
+	}
+	return strings.Join(services, " ")
+}
+
+func (s *serviceFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i == -1 || value[i+1:] == "" {
+		return errors.New("expected name=pidfile")
+	}
+	name := value[:i]
+	err := downtime.ValidateServiceName(name)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, service{name: name, pidFile: value[i+1:]})
+	return nil
+}
+
+type output struct {
+	path   string
+	policy downtime.SinkPolicy
+}
+
+// outputFlags collects the -o flags, each is a database path optionally followed by a comma and a sink policy
+type outputFlags []output
+
+func (o *outputFlags) String() string {
+	outputs := make([]string, len(*o))
+	for i, output := range *o {
+		outputs[i] = output.path + "," + output.policy.String()
+	}
+	return strings.Join(outputs, " ")
+}
+
+func (o *outputFlags) Set(value string) error {
+	out := output{path: value, policy: downtime.SinkRequired}
+	if i := strings.LastIndex(value, ","); i != -1 {
+		policy, err := downtime.ParseSinkPolicy(value[i+1:])
+		if err != nil {
+			return err
+		}
+		out = output{path: value[:i], policy: policy}
+	}
+	if out.path == "" {
+		return errors.New("missing database path")
+	}
+	*o = append(*o, out)
+	return nil
+}
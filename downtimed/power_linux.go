@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// powerFailureSignals are sent by UPS daemons when the power fails
+var powerFailureSignals = []os.Signal{syscall.SIGPWR}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+var powerFailureSignals = []os.Signal{}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abferm/downtime"
+)
+
+const day = 24 * time.Hour
+
+// command runs one of the maintenance commands that may follow the options
+func command(dbPath string, args []string) error {
+	switch args[0] {
+	case "rotate":
+		return rotate(dbPath, args[1:])
+	case "export":
+		return export(dbPath, args[1:])
+	case "import":
+		return importEvents(dbPath, args[1:])
+	case "backfill":
+		return backfill(dbPath, args[1:])
+	default:
+		err := fmt.Errorf("unknown command %q", args[0])
+		logger.Criticalf(err.Error())
+		return err
+	}
+}
+
+func rotate(dbPath string, args []string) error {
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	age := flags.Int("age", 365, "Move events older than this many days into a compressed archive.")
+	retain := flags.Int("retain", 0, "Delete archives whose newest event is older than this many days. Default is to keep them forever.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	archive, err := downtime.RotateDatabase(dbPath, now.Add(-time.Duration(*age)*day))
+	if err != nil {
+		logger.Criticalf(err.Error())
+		return err
+	}
+	if archive != "" {
+		fmt.Printf("archived to %s\n", archive)
+	}
+
+	if *retain > 0 {
+		pruned, err := downtime.PruneArchives(dbPath, now.Add(-time.Duration(*retain)*day))
+		if err != nil {
+			logger.Criticalf(err.Error())
+			return err
+		}
+		for _, path := range pruned {
+			fmt.Printf("removed %s\n", path)
+		}
+	}
+	return nil
+}
+
+func export(dbPath string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "json", "Output format, json or csv.")
+	output := flags.String("o", "-", "Write to this file instead of the standard output.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := downtime.OpenDatabaseSegments(dbPath)
+	if err != nil {
+		logger.Criticalf("can not open %s: %s", dbPath, err.Error())
+		return err
+	}
+	defer db.Close()
+	events, err := db.All()
+	if err != nil {
+		logger.Criticalf("can not read %s: %s", dbPath, err.Error())
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			logger.Criticalf(err.Error())
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch *format {
+	case "json":
+		err = downtime.ExportJSON(out, events)
+	case "csv":
+		err = downtime.ExportCSV(out, events)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		logger.Criticalf(err.Error())
+	}
+	return err
+}
+
+func importEvents(dbPath string, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "Input format, json or csv. Default is to guess from the file name.")
+	force := flags.Bool("force", false, "Replace the database if it already exists.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		err := fmt.Errorf("import needs exactly one input file")
+		logger.Criticalf(err.Error())
+		return err
+	}
+	input := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(input), ".")
+	}
+
+	_, err = os.Stat(dbPath)
+	if err == nil && !*force {
+		err := fmt.Errorf("%s already exists, use -force to replace it", dbPath)
+		logger.Criticalf(err.Error())
+		return err
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		logger.Criticalf(err.Error())
+		return err
+	}
+	defer f.Close()
+
+	var events []downtime.Event
+	switch *format {
+	case "json":
+		events, err = downtime.ImportJSON(f)
+	case "csv":
+		events, err = downtime.ImportCSV(f)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		logger.Criticalf("can not import %s: %s", input, err.Error())
+		return err
+	}
+
+	err = downtime.WriteDatabase(dbPath, events)
+	if err != nil {
+		logger.Criticalf("can not write %s: %s", dbPath, err.Error())
+		return err
+	}
+	fmt.Printf("imported %d events into %s\n", len(events), dbPath)
+	return nil
+}
+
+func backfill(dbPath string, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	wtmp := flags.String("wtmp", downtime.DefaultWtmpFile, "Read the boot and shutdown history from this wtmp file.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	records, err := downtime.ReadUtmpFile(*wtmp)
+	if err != nil {
+		logger.Criticalf("can not read %s: %s", *wtmp, err.Error())
+		return err
+	}
+	added, err := downtime.Backfill(dbPath, downtime.UtmpEvents(records))
+	if err != nil {
+		logger.Criticalf("can not backfill %s: %s", dbPath, err.Error())
+		return err
+	}
+	fmt.Printf("added %d events from %s to %s\n", added, *wtmp, dbPath)
+	return nil
+}
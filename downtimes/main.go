@@ -1,12 +1,12 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/abferm/downtime"
@@ -26,6 +26,16 @@ func execute() error {
 	dbPath := flag.String("d", filepath.Join(downtime.DefaultDataDir, downtime.DefaultDBFile), "Use the specified downtime database file instead of the system default.")
 	cTimeFormat := flag.String("f", downtime.DefaultTimeFormat, "Specify the time and date format to use when reporting using strftime(3) syntax.")
 	num := flag.Int64("n", -1, "Define how many latest downtime records to output. Default is all.")
+	precise := flag.Bool("p", false, "Display downtime durations with sub-second precision.")
+	since := flag.String("since", "", "Only output downtime that began at or after this time, given as \"2006-01-02 15:04:05\", \"2006-01-02\" or RFC 3339.")
+	until := flag.String("until", "", "Only output downtime that began before this time, in the same formats as -since.")
+	types := flag.String("type", "", "Only output downtime of these comma separated types, e.g. \"crash\", \"shutdown,crash\" or \"suspend\".")
+	kinds := flag.String("kind", "", "Only output shutdowns of these comma separated kinds: reboot, poweroff, halt, power-failure or unknown.")
+	availability := flag.Bool("a", false, "Also output the availability over the reported period, from -since or the first recorded event until -until or now.")
+	suspendUp := flag.Bool("suspend-up", false, "Count time the system was suspended as available.")
+	service := flag.String("service", "", "Output the downtime of this service tracked by downtimed(8) -service instead of the system.")
+	gaps := flag.Bool("gaps", true, "Also output the gaps in monitoring, while downtimed(8) was not running but the system was up.")
+	showBootID := flag.Bool("b", false, "Also output the ID of the boot that ended each downtime, as used by journalctl -b.")
 	sleep := flag.Int("s", downtime.DefaultSleepSeconds, "Calculate the approximate crash time by specifying what was the sleep value of downtimed(8).")
 	utc := flag.Bool("u", false, "Display times in UTC")
 	version := flag.Bool("v", false, "Display the program version number and copyright message.")
@@ -36,6 +46,10 @@ func execute() error {
 		return nil
 	}
 
+	if flag.NArg() > 0 {
+		return command(*dbPath, flag.Args())
+	}
+
 	goTimeFmt, err := downtime.StrftimeToGo(*cTimeFormat)
 	if err != nil {
 		logger.Criticalf("invalid time format: %s", err.Error())
@@ -43,97 +57,309 @@ func execute() error {
 	}
 	fmt.Println(goTimeFmt)
 
-	dbFile, err := os.Open(*dbPath)
+	loc := time.Local
+	if *utc {
+		loc = time.UTC
+	}
+	filter := outageFilter{}
+	filter.since, err = parseTime(*since, loc)
 	if err != nil {
-		logger.Criticalf("can not open %s: %s", *dbPath, err.Error())
+		logger.Criticalf("invalid -since: %s", err.Error())
 		return err
 	}
-
-	fInfo, err := dbFile.Stat()
+	filter.until, err = parseTime(*until, loc)
+	if err != nil {
+		logger.Criticalf("invalid -until: %s", err.Error())
+		return err
+	}
+	filter.types, err = parseTypes(*types)
+	if err != nil {
+		logger.Criticalf("invalid -type: %s", err.Error())
+		return err
+	}
+	filter.kinds, err = parseKinds(*kinds)
 	if err != nil {
-		logger.Criticalf("can not stat %s: %s", *dbPath, err.Error())
+		logger.Criticalf("invalid -kind: %s", err.Error())
 		return err
 	}
 
-	if (fInfo.Size() % downtime.EventSize) != 0 {
-		err := fmt.Errorf("database size is invalid")
-		logger.Criticalf(err.Error())
+	var events []downtime.Event
+	if *num != -1 && filter.empty() && !*availability {
+		// only read the end of the database, and the archives if the live file is not enough
+		events, err = downtime.LastSegmentEvents(*dbPath, *service, int(*num))
+	} else {
+		events, err = querySegments(*dbPath, downtime.Query{Since: filter.since, Services: []string{*service}})
+	}
+	if err != nil {
+		logger.Criticalf("can not read %s: %s", *dbPath, err.Error())
 		return err
 	}
-	if (*num != -1) && (fInfo.Size() > (*num * downtime.EventSize * 2)) {
-		_, err := dbFile.Seek(*num*downtime.EventSize*-2, os.SEEK_END)
-		if err != nil {
-			logger.Criticalf("can not seek: %s", err.Error())
-			return err
-		}
+	var listed []downtime.Outage
+	outages := filter.apply(downtime.Outages(events))
+	if *gaps {
+		listed = filter.apply(downtime.CoverageGaps(events))
+	}
+	from, to := filter.since, filter.until
+	if from.IsZero() && len(events) > 0 {
+		from = events[0].When.AsTime()
+	}
+	if to.IsZero() {
+		to = time.Now()
 	}
 
-	db := downtime.NewDatabaseReader(dbFile)
+	var available float64
+	if *availability {
+		counted := []downtime.Outage{}
+		for _, outage := range outages {
+			if !(*suspendUp && outage.Suspended()) {
+				counted = append(counted, outage)
+			}
+		}
+		available = downtime.Availability(counted, from, to)
+	}
+	listed = append(listed, unexplained(outages, listed)...)
+	sort.SliceStable(listed, func(i, j int) bool {
+		return start(listed[i]).When < start(listed[j]).When
+	})
+	if *num != -1 && len(listed) > int(*num) {
+		listed = listed[len(listed)-int(*num):]
+	}
 
-	var tdown time.Time
-	var crashed bool
 	// adjust crash time assuming we crashed in the middle of our sleep time
 	var tadjust = (time.Duration(*sleep) * time.Second) / 2
+	crashes := downtime.NewCrashArchive(filepath.Dir(*dbPath))
 
-	var evt downtime.Event
-	for evt, err = db.Next(); err == nil; evt, err = db.Next() {
-		when := evt.When.AsTime()
-		if *utc {
-			when = when.UTC()
-		} else {
-			when = when.Local()
+	for _, outage := range listed {
+		tdown := eventTime(outage.Down, *utc)
+		var headline string
+		if outage.Crashed() {
+			tdown = tdown.Add(tadjust)
+			headline, _ = crashes.Headline(outage.Down)
 		}
-		switch evt.What {
-		case downtime.EventTypeShutdown:
-			if !tdown.IsZero() {
-				// there was a missing up event, report the previous down with unknown duration
-				report(tdown, time.Time{}, crashed, goTimeFmt)
-			}
-			tdown = when
-			crashed = false
-		case downtime.EventTypeCrash:
-			if !tdown.IsZero() {
-				// there was a missing up event, report the previous down with unknown duration
-				report(tdown, time.Time{}, crashed, goTimeFmt)
-			}
-			crashed = true
-			tdown = when.Add(tadjust)
-		case downtime.EventTypeUp:
-			report(tdown, when, crashed, goTimeFmt)
-			tdown = time.Time{}
+		report(outage, tdown, eventTime(outage.Up, *utc), goTimeFmt, *precise, *showBootID, headline)
+	}
+	if *availability {
+		fmt.Printf("available %.3f%% from %s to %s\n", available*100, zoned(from, *utc).Format(goTimeFmt), zoned(to, *utc).Format(goTimeFmt))
+	}
+	return nil
+}
+
+// querySegments runs q on the database at dbPath and the archives that may hold events since q.Since
+func querySegments(dbPath string, q downtime.Query) ([]downtime.Event, error) {
+	db, err := downtime.OpenDatabaseSegmentsSince(dbPath, q.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// the up event of an outage that began before -until may be after it, so only the start is bounded here
+	return db.Query(q)
+}
+
+type outageFilter struct {
+	since, until time.Time
+	types        []downtime.EventType
+	kinds        []downtime.ShutdownKind
+}
+
+func (f outageFilter) empty() bool {
+	return f.since.IsZero() && f.until.IsZero() && len(f.types) == 0 && len(f.kinds) == 0
+}
+
+func (f outageFilter) apply(outages []downtime.Outage) []downtime.Outage {
+	filtered := []downtime.Outage{}
+	for _, outage := range outages {
+		if !f.until.IsZero() && !start(outage).When.AsTime().Before(f.until) {
+			continue
+		}
+		if len(f.types) > 0 && !containsType(f.types, outage.Down.What) {
+			continue
+		}
+		if len(f.kinds) > 0 && (outage.Down.What != downtime.EventTypeShutdown || !containsKind(f.kinds, outage.Down.Kind)) {
+			continue
 		}
+		filtered = append(filtered, outage)
 	}
+	return filtered
+}
 
-	if err != nil && !errors.Is(err, io.EOF) {
-		logger.Criticalf(err.Error())
-		return err
+// unexplained leaves out the outages without a down event that ended one of the gaps, the gap already shows them
+func unexplained(outages, gaps []downtime.Outage) []downtime.Outage {
+	ends := map[downtime.Event]bool{}
+	for _, gap := range gaps {
+		ends[gap.Up] = true
 	}
-	return nil
+	kept := []downtime.Outage{}
+	for _, outage := range outages {
+		if outage.Down.What == downtime.EventTypeNone && ends[outage.Up] {
+			continue
+		}
+		kept = append(kept, outage)
+	}
+	return kept
+}
+
+// start returns the first known event of an outage
+func start(outage downtime.Outage) downtime.Event {
+	if outage.Down.What == downtime.EventTypeNone {
+		return outage.Up
+	}
+	return outage.Down
 }
 
-func report(tDown, tUp time.Time, crashed bool, timeFormat string) {
-	if crashed {
+func containsType(types []downtime.EventType, what downtime.EventType) bool {
+	for _, t := range types {
+		if t == what {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKind(kinds []downtime.ShutdownKind, kind downtime.ShutdownKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
+
+// parseTypes parses a comma separated list of event type names, ignoring case
+func parseTypes(value string) ([]downtime.EventType, error) {
+	types := []downtime.EventType{}
+	if value == "" {
+		return types, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		what, err := eventTypeByName(name)
+		if err != nil {
+			return types, err
+		}
+		types = append(types, what)
+	}
+	return types, nil
+}
+
+// eventTypeByName looks up an event type by its name ignoring case, e.g. monitorstop for MonitorStop
+func eventTypeByName(name string) (downtime.EventType, error) {
+	// the types are numbered from None up, String stops giving a name after the last one
+	for what := downtime.EventTypeNone; ; what++ {
+		if _, err := downtime.ParseEventType(what.String()); err != nil {
+			break
+		}
+		if strings.EqualFold(what.String(), name) {
+			return what, nil
+		}
+	}
+	return downtime.EventTypeNone, fmt.Errorf("%s is not a valid EventType", name)
+}
+
+// parseKinds parses a comma separated list of shutdown kinds, ignoring case
+func parseKinds(value string) ([]downtime.ShutdownKind, error) {
+	kinds := []downtime.ShutdownKind{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		kind, err := downtime.ParseShutdownKind(strings.ToLower(name))
+		if err != nil {
+			return kinds, err
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// eventTime returns the time of evt in the requested zone, or the zero time if the event is missing
+func eventTime(evt downtime.Event, utc bool) time.Time {
+	if evt.What == downtime.EventTypeNone {
+		return time.Time{}
+	}
+	return zoned(evt.When.AsTime(), utc)
+}
+
+func zoned(t time.Time, utc bool) time.Time {
+	if utc {
+		return t.UTC()
+	}
+	return t.Local()
+}
+
+func report(outage downtime.Outage, tDown, tUp time.Time, timeFormat string, precise, showBootID bool, headline string) {
+	switch {
+	case outage.Gap() && outage.Up.What == downtime.EventTypeUp:
+		fmt.Printf("gap   %s -> ", tDown.Format(timeFormat))
+		fmt.Printf("up %s ", tUp.Format(timeFormat))
+	case outage.Gap():
+		fmt.Printf("gap   %s -> ", tDown.Format(timeFormat))
+		fmt.Printf("back %s ", tUp.Format(timeFormat))
+	case outage.Suspended():
+		fmt.Printf("sleep %s -> ", tDown.Format(timeFormat))
+		fmt.Printf("wake %s ", tUp.Format(timeFormat))
+	case outage.Crashed():
 		fmt.Printf("crash %s -> ", tDown.Format(timeFormat))
-	} else {
+		fmt.Printf("up %s ", tUp.Format(timeFormat))
+	default:
 		fmt.Printf("down  %s -> ", tDown.Format(timeFormat))
+		fmt.Printf("up %s ", tUp.Format(timeFormat))
 	}
 
-	fmt.Printf("up %s ", tUp.Format(timeFormat))
-
 	if tDown.IsZero() || tUp.IsZero() {
-		fmt.Printf("= %11s (? s)\n", "unknown")
+		fmt.Printf("= %11s (? s)", "unknown")
+	} else if precise {
+		downDuration := tUp.Sub(tDown)
+		fmt.Printf("= %15s (%.3f s)", formatDuration(downDuration, true), downDuration.Seconds())
 	} else {
 		downDuration := tUp.Sub(tDown)
-		fmt.Printf("= %11s (%d s)\n", formatDuration(downDuration), int(downDuration.Seconds()))
+		fmt.Printf("= %11s (%d s)", formatDuration(downDuration, false), int(downDuration.Seconds()))
+	}
+	if outage.Down.Kind != downtime.ShutdownKindUnknown {
+		fmt.Printf(" %s", outage.Down.Kind)
+	}
+	if showBootID && !outage.Up.BootID.IsZero() {
+		fmt.Printf(" boot %s", outage.Up.BootID)
 	}
+	if headline != "" {
+		fmt.Printf(" [%s]", headline)
+	}
+	fmt.Println()
 }
 
-func formatDuration(dur time.Duration) string {
+func formatDuration(dur time.Duration, precise bool) string {
 	d := int(dur.Hours()) / 24
 	h := int(dur.Hours()) % 24
 	m := int(dur.Minutes()) % 60
 	s := int(dur.Seconds()) % 60
 	hms := fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	if precise {
+		hms = fmt.Sprintf("%s.%03d", hms, dur.Milliseconds()%1000)
+	}
 	if d == 0 {
 		return hms
 	} else {
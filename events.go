@@ -7,21 +7,28 @@ import (
 	"time"
 )
 
-const EventSize = 16
+// EventSize is the size of a record written by DatabaseWriter.
+const EventSize = 72
 
 /*ENUM(
 None = 0
 Up = 1
 Shutdown = 2
 Crash = 3
+Suspend = 4
+Resume = 5
+ClockJump = 6
+MonitorStop = 7
+MonitorStart = 8
 )
 */
 type EventType uint8
 
+// UnixTimestamp is the number of nanoseconds elapsed since the unix epoch
 type UnixTimestamp int64
 
 func (ut UnixTimestamp) AsTime() time.Time {
-	return time.Unix(int64(ut), 0)
+	return time.Unix(0, int64(ut))
 }
 
 func (ut UnixTimestamp) String() string {
@@ -31,16 +38,41 @@ func (ut UnixTimestamp) String() string {
 func NewEvent(what EventType, when time.Time) Event {
 	return Event{
 		What: what,
-		When: UnixTimestamp(when.Unix()),
+		When: UnixTimestamp(when.UnixNano()),
 	}
 }
 
+// NewClockJump records that the clock was stepped by offset, when is the time after the step.
+func NewClockJump(when time.Time, offset time.Duration) Event {
+	event := NewEvent(EventTypeClockJump, when)
+	event.Offset = offset
+	return event
+}
+
 type Event struct {
 	What EventType
 	_    [7]uint8 // padding
 	When UnixTimestamp
+	// Offset is how far the clock was stepped, only set for ClockJump events.
+	Offset time.Duration
+	// BootID is the boot that began with an Up event, if known.
+	BootID BootID
+	// Service is the name of the tracked service the event belongs to, empty for the system.
+	Service string
+	// Kind of shutdown, only set for Shutdown events.
+	Kind ShutdownKind
 }
 
 func (e Event) String() string {
-	return fmt.Sprintf("%s at %s", e.What, e.When)
+	s := fmt.Sprintf("%s at %s", e.What, e.When)
+	if e.Offset != 0 {
+		s = fmt.Sprintf("%s by %s at %s", e.What, e.Offset, e.When)
+	}
+	if e.Kind != ShutdownKindUnknown {
+		s = fmt.Sprintf("%s (%s) at %s", e.What, e.Kind, e.When)
+	}
+	if e.Service != "" {
+		s = e.Service + ": " + s
+	}
+	return s
 }
@@ -19,15 +19,30 @@ const (
 	EventTypeShutdown
 	// EventTypeCrash is a EventType of type Crash.
 	EventTypeCrash
+	// EventTypeSuspend is a EventType of type Suspend.
+	EventTypeSuspend
+	// EventTypeResume is a EventType of type Resume.
+	EventTypeResume
+	// EventTypeClockJump is a EventType of type ClockJump.
+	EventTypeClockJump
+	// EventTypeMonitorStop is a EventType of type MonitorStop.
+	EventTypeMonitorStop
+	// EventTypeMonitorStart is a EventType of type MonitorStart.
+	EventTypeMonitorStart
 )
 
-const _EventTypeName = "NoneUpShutdownCrash"
+const _EventTypeName = "NoneUpShutdownCrashSuspendResumeClockJumpMonitorStopMonitorStart"
 
 var _EventTypeMap = map[EventType]string{
-	EventTypeNone:     _EventTypeName[0:4],
-	EventTypeUp:       _EventTypeName[4:6],
-	EventTypeShutdown: _EventTypeName[6:14],
-	EventTypeCrash:    _EventTypeName[14:19],
+	EventTypeNone:         _EventTypeName[0:4],
+	EventTypeUp:           _EventTypeName[4:6],
+	EventTypeShutdown:     _EventTypeName[6:14],
+	EventTypeCrash:        _EventTypeName[14:19],
+	EventTypeSuspend:      _EventTypeName[19:26],
+	EventTypeResume:       _EventTypeName[26:32],
+	EventTypeClockJump:    _EventTypeName[32:41],
+	EventTypeMonitorStop:  _EventTypeName[41:52],
+	EventTypeMonitorStart: _EventTypeName[52:64],
 }
 
 // String implements the Stringer interface.
@@ -43,6 +58,11 @@ var _EventTypeValue = map[string]EventType{
 	_EventTypeName[4:6]:   EventTypeUp,
 	_EventTypeName[6:14]:  EventTypeShutdown,
 	_EventTypeName[14:19]: EventTypeCrash,
+	_EventTypeName[19:26]: EventTypeSuspend,
+	_EventTypeName[26:32]: EventTypeResume,
+	_EventTypeName[32:41]: EventTypeClockJump,
+	_EventTypeName[41:52]: EventTypeMonitorStop,
+	_EventTypeName[52:64]: EventTypeMonitorStart,
 }
 
 // ParseEventType attempts to convert a string to a EventType.
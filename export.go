@@ -0,0 +1,221 @@
+package downtime
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type jsonEvent struct {
+	What    EventType     `json:"what"`
+	When    UnixTimestamp `json:"when"`
+	Offset  string        `json:"offset,omitempty"`
+	BootID  string        `json:"boot_id,omitempty"`
+	Service string        `json:"service,omitempty"`
+	Kind    string        `json:"kind,omitempty"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEvent{
+		What:    e.What,
+		When:    e.When,
+		Offset:  formatOffset(e.Offset),
+		BootID:  e.BootID.String(),
+		Service: e.Service,
+		Kind:    formatKind(e.Kind),
+	})
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var je jsonEvent
+	err := json.Unmarshal(data, &je)
+	if err != nil {
+		return err
+	}
+	offset, err := parseOffset(je.Offset)
+	if err != nil {
+		return err
+	}
+	bootID, err := parseOptionalBootID(je.BootID)
+	if err != nil {
+		return err
+	}
+	kind, err := parseKind(je.Kind)
+	if err != nil {
+		return err
+	}
+	*e = Event{
+		What:    je.What,
+		When:    je.When,
+		Offset:  offset,
+		BootID:  bootID,
+		Service: je.Service,
+		Kind:    kind,
+	}
+	return nil
+}
+
+func parseOptionalBootID(s string) (BootID, error) {
+	if s == "" {
+		return BootID{}, nil
+	}
+	return ParseBootID(s)
+}
+
+// formatKind formats the kind of a Shutdown event, empty if it is unknown
+func formatKind(kind ShutdownKind) string {
+	if kind == ShutdownKindUnknown {
+		return ""
+	}
+	return kind.String()
+}
+
+func parseKind(s string) (ShutdownKind, error) {
+	if s == "" {
+		return ShutdownKindUnknown, nil
+	}
+	return ParseShutdownKind(s)
+}
+
+// formatOffset formats the offset of a ClockJump event as a Go duration, empty if there is none
+func formatOffset(offset time.Duration) string {
+	if offset == 0 {
+		return ""
+	}
+	return offset.String()
+}
+
+func parseOffset(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// MarshalJSON encodes the timestamp as an RFC 3339 time in UTC.
+func (ut UnixTimestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ut.AsTime().UTC().Format(time.RFC3339Nano))
+}
+
+func (ut *UnixTimestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	return ut.parse(s)
+}
+
+func (ut *UnixTimestamp) parse(s string) error {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*ut = UnixTimestamp(t.UnixNano())
+	return nil
+}
+
+// csvHeader lists the columns written by ExportCSV, files written by older versions lack the last columns
+var csvHeader = []string{"what", "when", "offset", "boot_id", "service", "kind"}
+
+// csvMinFields is the number of columns in files written by the first version of ExportCSV
+const csvMinFields = 2
+
+// ExportJSON writes events as a JSON array.
+func ExportJSON(w io.Writer, events []Event) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
+
+// ExportCSV writes events as CSV with a header line, times are RFC 3339 in UTC.
+func ExportCSV(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		err = cw.Write([]string{
+			event.What.String(),
+			event.When.AsTime().UTC().Format(time.RFC3339Nano),
+			formatOffset(event.Offset),
+			event.BootID.String(),
+			event.Service,
+			formatKind(event.Kind),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ImportJSON reads events written by ExportJSON.
+func ImportJSON(r io.Reader) ([]Event, error) {
+	events := []Event{}
+	err := json.NewDecoder(r).Decode(&events)
+	if err != nil {
+		return nil, err
+	}
+	return events, validateEvents(events)
+}
+
+// ImportCSV reads events written by ExportCSV.
+func ImportCSV(r io.Reader) ([]Event, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for i, record := range records {
+		if i == 0 && record[0] == csvHeader[0] {
+			continue
+		}
+		if len(record) < csvMinFields || len(record) > len(csvHeader) {
+			return nil, fmt.Errorf("line %d: %d fields, expected %d", i+1, len(record), len(csvHeader))
+		}
+		var event Event
+		err = event.What.UnmarshalText([]byte(record[0]))
+		if err == nil {
+			err = event.When.parse(record[1])
+		}
+		if err == nil && len(record) > 2 {
+			event.Offset, err = parseOffset(record[2])
+		}
+		if err == nil && len(record) > 3 {
+			event.BootID, err = parseOptionalBootID(record[3])
+		}
+		if len(record) > 4 {
+			event.Service = record[4]
+		}
+		if err == nil && len(record) > 5 {
+			event.Kind, err = parseKind(record[5])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+	return events, validateEvents(events)
+}
+
+func validateEvents(events []Event) error {
+	for i, event := range events {
+		if !validEventType(event.What) {
+			return fmt.Errorf("event %d: %w: %s", i+1, ErrInvalidRecord, event.What)
+		}
+		if event.Service != "" {
+			err := ValidateServiceName(event.Service)
+			if err != nil {
+				return fmt.Errorf("event %d: %w: %s", i+1, ErrInvalidRecord, err)
+			}
+		}
+	}
+	return nil
+}
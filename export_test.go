@@ -0,0 +1,100 @@
+package downtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventJSON(t *testing.T) {
+	event := downtime.NewEvent(downtime.EventTypeCrash, time.Date(2021, time.October, 6, 1, 2, 3, 500000000, time.UTC))
+	data, err := json.Marshal(event)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"what":"Crash","when":"2021-10-06T01:02:03.5Z"}`, string(data))
+
+	var decoded downtime.Event
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, event, decoded)
+
+	jump := downtime.NewClockJump(time.Date(2021, time.October, 6, 1, 2, 3, 0, time.UTC), -90*time.Second)
+	data, err = json.Marshal(jump)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"what":"ClockJump","when":"2021-10-06T01:02:03Z","offset":"-1m30s"}`, string(data))
+	decoded = downtime.Event{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, jump, decoded)
+
+	up := downtime.NewEvent(downtime.EventTypeUp, time.Date(2021, time.October, 6, 1, 3, 0, 0, time.UTC))
+	up.BootID, err = downtime.ParseBootID("b6e7c1a2d8f94c0e9a3b5f1d2e4c6a80")
+	assert.NoError(t, err)
+	data, err = json.Marshal(up)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"what":"Up","when":"2021-10-06T01:03:00Z","boot_id":"b6e7c1a2-d8f9-4c0e-9a3b-5f1d2e4c6a80"}`, string(data))
+	decoded = downtime.Event{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, up, decoded)
+
+	service := downtime.NewEvent(downtime.EventTypeCrash, time.Date(2021, time.October, 6, 1, 4, 0, 0, time.UTC))
+	service.Service = "api"
+	data, err = json.Marshal(service)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"what":"Crash","when":"2021-10-06T01:04:00Z","service":"api"}`, string(data))
+	decoded = downtime.Event{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, service, decoded)
+
+	shutdown := downtime.NewEvent(downtime.EventTypeShutdown, time.Date(2021, time.October, 6, 1, 5, 0, 0, time.UTC))
+	shutdown.Kind = downtime.ShutdownKindPowerFailure
+	data, err = json.Marshal(shutdown)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"what":"Shutdown","when":"2021-10-06T01:05:00Z","kind":"power-failure"}`, string(data))
+	decoded = downtime.Event{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, shutdown, decoded)
+
+	buff := bytes.NewBuffer([]byte{})
+	assert.NoError(t, downtime.ExportCSV(buff, []downtime.Event{event, jump, up, service, shutdown}))
+	assert.Equal(t, "what,when,offset,boot_id,service,kind\n"+
+		"Crash,2021-10-06T01:02:03.5Z,,,,\n"+
+		"ClockJump,2021-10-06T01:02:03Z,-1m30s,,,\n"+
+		"Up,2021-10-06T01:03:00Z,,b6e7c1a2-d8f9-4c0e-9a3b-5f1d2e4c6a80,,\n"+
+		"Crash,2021-10-06T01:04:00Z,,,api,\n"+
+		"Shutdown,2021-10-06T01:05:00Z,,,,power-failure\n", buff.String())
+	imported, err := downtime.ImportCSV(buff)
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Event{event, jump, up, service, shutdown}, imported)
+}
+
+func TestExportImport(t *testing.T) {
+	r, err := downtime.OpenDatabaseReader("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	events, err := r.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff := bytes.NewBuffer([]byte{})
+	assert.NoError(t, downtime.ExportJSON(buff, events))
+	imported, err := downtime.ImportJSON(buff)
+	assert.NoError(t, err)
+	assert.Equal(t, events, imported)
+
+	buff.Reset()
+	assert.NoError(t, downtime.ExportCSV(buff, events))
+	imported, err = downtime.ImportCSV(buff)
+	assert.NoError(t, err)
+	assert.Equal(t, events, imported)
+
+	_, err = downtime.ImportCSV(strings.NewReader("what,when\nNone,2021-10-06T01:02:03Z\n"))
+	assert.ErrorIs(t, err, downtime.ErrInvalidRecord)
+	_, err = downtime.ImportCSV(strings.NewReader("what,when,offset,boot_id,service\nUp,2021-10-06T01:02:03Z,,,../api\n"))
+	assert.ErrorIs(t, err, downtime.ErrInvalidRecord)
+}
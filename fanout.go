@@ -0,0 +1,243 @@
+package downtime
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SinkPolicy decides what happens when a sink of a FanOutWriter fails.
+type SinkPolicy int
+
+const (
+	// SinkRequired fails the append, and with it Daemon.Init.
+	SinkRequired SinkPolicy = iota
+	// SinkBestEffort logs the failure and drops the event for this sink.
+	SinkBestEffort
+	// SinkRetry logs the failure and queues the event, queued events are written before any
+	// newer ones on the next append or call to Retry.
+	SinkRetry
+)
+
+var sinkPolicyNames = map[SinkPolicy]string{
+	SinkRequired:   "required",
+	SinkBestEffort: "best-effort",
+	SinkRetry:      "retry",
+}
+
+func (p SinkPolicy) String() string {
+	if name, ok := sinkPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("SinkPolicy(%d)", int(p))
+}
+
+// ParseSinkPolicy parses the names returned by SinkPolicy.String.
+func ParseSinkPolicy(name string) (SinkPolicy, error) {
+	for policy, n := range sinkPolicyNames {
+		if n == name {
+			return policy, nil
+		}
+	}
+	return SinkRequired, fmt.Errorf("%s is not a valid SinkPolicy", name)
+}
+
+// MaxQueuedEvents is how many events a SinkRetry sink queues before dropping the oldest.
+const MaxQueuedEvents = 1024
+
+// Sink is one of the writers of a FanOutWriter.
+type Sink struct {
+	Name   string
+	Writer EventWriter
+	Policy SinkPolicy
+}
+
+// FanOutError lists the sinks that failed, by name.
+type FanOutError struct {
+	Failures map[string]error
+}
+
+func (e *FanOutError) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Failures[name])
+	}
+	return "sinks failed: " + strings.Join(msgs, "; ")
+}
+
+// NewFanOutWriter appends every event to each of the sinks.
+func NewFanOutWriter(sinks ...Sink) *FanOutWriter {
+	w := &FanOutWriter{}
+	for _, sink := range sinks {
+		w.sinks = append(w.sinks, &sinkState{Sink: sink})
+	}
+	return w
+}
+
+// FanOutWriter is an EventWriter that appends to several sinks, see NewFanOutWriter.
+type FanOutWriter struct {
+	mu    sync.Mutex
+	sinks []*sinkState
+}
+
+type sinkState struct {
+	Sink
+	queue []Event
+}
+
+// flush writes the queued events of a SinkRetry sink
+func (s *sinkState) flush() error {
+	for len(s.queue) > 0 {
+		err := s.Writer.Append(s.queue[0])
+		if err != nil {
+			return err
+		}
+		s.queue = s.queue[1:]
+	}
+	return nil
+}
+
+func (s *sinkState) enqueue(event Event) {
+	if len(s.queue) >= MaxQueuedEvents {
+		logger.Warningf("sink %s: queue full, dropping %s", s.Name, s.queue[0])
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, event)
+}
+
+// Append writes event to every sink. The returned error is a *FanOutError listing every failed sink
+// if any SinkRequired sink failed, failures of other sinks are only logged.
+func (w *FanOutWriter) Append(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	failures := map[string]error{}
+	required := false
+	for _, sink := range w.sinks {
+		if sink.Policy == SinkRetry && len(sink.queue) > 0 {
+			// keep the order, the new event goes behind the ones still waiting
+			sink.enqueue(event)
+			err := sink.flush()
+			if err != nil {
+				failures[sink.Name] = err
+				logger.Errorf("sink %s: queued %s for retry: %s", sink.Name, event, err)
+			}
+			continue
+		}
+		err := sink.Writer.Append(event)
+		if err == nil {
+			continue
+		}
+		failures[sink.Name] = err
+		switch sink.Policy {
+		case SinkRequired:
+			required = true
+		case SinkBestEffort:
+			logger.Errorf("sink %s: dropping %s: %s", sink.Name, event, err)
+		case SinkRetry:
+			sink.enqueue(event)
+			logger.Errorf("sink %s: queued %s for retry: %s", sink.Name, event, err)
+		}
+	}
+	if required {
+		return &FanOutError{Failures: failures}
+	}
+	return nil
+}
+
+// Retry writes the events queued for SinkRetry sinks, it returns a *FanOutError listing the sinks that still fail.
+func (w *FanOutWriter) Retry() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	failures := map[string]error{}
+	for _, sink := range w.sinks {
+		err := sink.flush()
+		if err != nil {
+			failures[sink.Name] = err
+		}
+	}
+	if len(failures) > 0 {
+		return &FanOutError{Failures: failures}
+	}
+	return nil
+}
+
+// Pending returns how many events are queued for each SinkRetry sink that has any.
+func (w *FanOutWriter) Pending() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pending := map[string]int{}
+	for _, sink := range w.sinks {
+		if len(sink.queue) > 0 {
+			pending[sink.Name] = len(sink.queue)
+		}
+	}
+	return pending
+}
+
+/*
+LazyDatabaseWriter opens the database at path on the first append, and again on every later append
+until that succeeds. It is the Writer of a SinkRetry sink whose disk may not be mounted when the daemon
+starts, the events are queued until the database can be opened.
+*/
+type LazyDatabaseWriter struct {
+	path string
+	db   *DatabaseWriter
+}
+
+func NewLazyDatabaseWriter(path string) *LazyDatabaseWriter {
+	return &LazyDatabaseWriter{path: path}
+}
+
+func (w *LazyDatabaseWriter) Append(event Event) error {
+	if w.db == nil {
+		db, err := OpenDatabaseWriter(w.path)
+		if err != nil {
+			return err
+		}
+		logger.Infof("opened %s", w.path)
+		w.db = db
+	}
+	return w.db.Append(event)
+}
+
+func (w *LazyDatabaseWriter) Close() error {
+	if w.db == nil {
+		return nil
+	}
+	return w.db.Close()
+}
+
+// Close closes every sink, queued events that can still not be written are lost.
+func (w *FanOutWriter) Close() error {
+	err := w.Retry()
+	var fanOutErr *FanOutError
+	if errors.As(err, &fanOutErr) {
+		for name, err := range fanOutErr.Failures {
+			logger.Errorf("sink %s: losing %d queued events: %s", name, w.Pending()[name], err)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	failures := map[string]error{}
+	for _, sink := range w.sinks {
+		err := sink.Writer.Close()
+		if err != nil {
+			failures[sink.Name] = err
+		}
+	}
+	if len(failures) > 0 {
+		return &FanOutError{Failures: failures}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package downtime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSink records appended events and fails while err is set
+type mockSink struct {
+	events []Event
+	err    error
+	closed bool
+}
+
+func (s *mockSink) Append(event Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *mockSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFanOutWriter(t *testing.T) {
+	required, bestEffort, retry := &mockSink{}, &mockSink{}, &mockSink{}
+	w := NewFanOutWriter(
+		Sink{Name: "required", Writer: required, Policy: SinkRequired},
+		Sink{Name: "best-effort", Writer: bestEffort, Policy: SinkBestEffort},
+		Sink{Name: "retry", Writer: retry, Policy: SinkRetry},
+	)
+	events := []Event{
+		NewEvent(EventTypeCrash, time.Unix(1000, 0)),
+		NewEvent(EventTypeUp, time.Unix(2000, 0)),
+		NewEvent(EventTypeShutdown, time.Unix(3000, 0)),
+	}
+
+	bestEffort.err = fmt.Errorf("disk full")
+	retry.err = fmt.Errorf("not mounted")
+	assert.NoError(t, w.Append(events[0]))
+	assert.NoError(t, w.Append(events[1]))
+	assert.Equal(t, events[:2], required.events)
+	assert.Empty(t, bestEffort.events)
+	assert.Empty(t, retry.events)
+	assert.Equal(t, map[string]int{"retry": 2}, w.Pending())
+
+	retry.err = nil
+	bestEffort.err = nil
+	assert.NoError(t, w.Append(events[2]))
+	assert.Equal(t, events, retry.events, "queued events are written first")
+	assert.Equal(t, events[2:], bestEffort.events)
+	assert.Empty(t, w.Pending())
+
+	required.err = fmt.Errorf("read-only file system")
+	bestEffort.err = fmt.Errorf("disk full")
+	err := w.Append(events[0])
+	var fanOutErr *FanOutError
+	if assert.True(t, errors.As(err, &fanOutErr)) {
+		assert.Equal(t, map[string]error{"required": required.err, "best-effort": bestEffort.err}, fanOutErr.Failures)
+	}
+	assert.EqualError(t, err, "sinks failed: best-effort: disk full; required: read-only file system")
+
+	assert.NoError(t, w.Close())
+	assert.True(t, required.closed && bestEffort.closed && retry.closed)
+}
+
+func TestDaemonRetriesFanOut(t *testing.T) {
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	backup := &mockSink{err: fmt.Errorf("not mounted")}
+	w := NewFanOutWriter(
+		Sink{Name: "downtimedb", Writer: NewDatabaseWriter(bytes.NewBuffer([]byte{})), Policy: SinkRequired},
+		Sink{Name: "backup", Writer: backup, Policy: SinkRetry},
+	)
+	d := NewDaemonWithClock(store, w, DefaultSleepSeconds*time.Second, clk)
+
+	store.boot = boot
+	clk.Set(boot.Add(time.Hour))
+	d.stamp(false)
+	assert.NoError(t, d.Init(boot.Add(2*time.Hour), time.Stamp))
+	assert.Equal(t, map[string]int{"backup": 2}, w.Pending())
+
+	backup.err = nil
+	d.stamp(false)
+	assert.Empty(t, w.Pending())
+	assert.Len(t, backup.events, 2)
+
+	failing := NewFanOutWriter(Sink{Name: "downtimedb", Writer: &mockSink{err: fmt.Errorf("disk full")}, Policy: SinkRequired})
+	d = NewDaemonWithClock(store, failing, DefaultSleepSeconds*time.Second, clk)
+	d.stamp(false)
+	assert.Error(t, d.Init(boot.Add(3*time.Hour), time.Stamp))
+}
+
+func TestLazyDatabaseWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "unmounted")
+	path := filepath.Join(dir, DefaultDBFile)
+	w := NewFanOutWriter(Sink{Name: path, Writer: NewLazyDatabaseWriter(path), Policy: SinkRetry})
+	event := NewEvent(EventTypeUp, time.Unix(1633484567, 0))
+	assert.NoError(t, w.Append(event))
+	assert.Equal(t, map[string]int{path: 1}, w.Pending(), "queued until the database can be opened")
+
+	require.NoError(t, os.Mkdir(dir, 0755))
+	assert.NoError(t, w.Retry())
+	assert.Empty(t, w.Pending())
+	assert.NoError(t, w.Close())
+
+	r, err := OpenDatabaseReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	events, err := r.All()
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event}, events)
+}
+
+func TestParseSinkPolicy(t *testing.T) {
+	for _, policy := range []SinkPolicy{SinkRequired, SinkBestEffort, SinkRetry} {
+		parsed, err := ParseSinkPolicy(policy.String())
+		assert.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+	_, err := ParseSinkPolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,430 @@
+package downtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultStateFile is the name of the FileStore in the data directory.
+const DefaultStateFile = "downtimed.state"
+
+/*
+FileStore is a DataStore keeping all of its state in a single checksummed file, which is replaced as a
+whole on every update. Unlike DataDir it does not depend on file times, so it keeps nanoseconds on any
+file system and survives a crash during an update with either the old or the new state.
+
+The file is 64 bytes:
+
+	magic    [4]byte  "DTST"
+	version  uint8    1
+	kind     uint8    ShutdownKind of the last shutdown
+	present  uint8    bit set of the fields below that are stored, and the kind
+	padding  uint8    zero
+	crc      uint32   IEEE CRC-32 of the file with this field zeroed
+	padding  [4]byte  zero
+	stamp    int64    nanoseconds since the unix epoch
+	shutdown int64    nanoseconds since the unix epoch
+	boot     int64    nanoseconds since the unix epoch
+	stop     int64    nanoseconds since the unix epoch the monitor stopped
+	boot id  [16]byte
+*/
+type FileStore struct {
+	path string
+	// lock is held on the directory, the stores of services share the lock of their parent
+	lock  *os.File
+	mu    sync.Mutex
+	state fileState
+	// err is why the file could not be read, reported by the getters until the state is replaced
+	err error
+}
+
+const (
+	fileStoreMagic   = "DTST"
+	fileStoreVersion = 1
+	fileStoreSize    = 64
+)
+
+const (
+	presentStamp uint8 = 1 << iota
+	presentShutdown
+	presentBoot
+	presentMonitorStop
+	presentBootID
+	presentShutdownKind
+)
+
+type fileState struct {
+	present     uint8
+	kind        ShutdownKind
+	stamp       int64
+	shutdown    int64
+	boot        int64
+	monitorStop int64
+	bootID      BootID
+}
+
+// NewFileStore opens the FileStore at path, which is created on the first update if it does not exist.
+// The directory holding it is locked like by NewDataDir until Close.
+func NewFileStore(path string) (*FileStore, error) {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load datadir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	lock, err := lockDataDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := openFileStore(path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	fs.lock = lock
+	return fs, nil
+}
+
+// FileStore opens the FileStore in DefaultStateFile of the directory and hands the lock held by dd over
+// to it, so there is no moment the directory is unlocked. Closing dd no longer releases the lock then,
+// but the state it keeps can still be read, e.g. by MigrateDataDir.
+func (dd *DataDir) FileStore() (*FileStore, error) {
+	fs, err := openFileStore(filepath.Join(dd.dir, DefaultStateFile))
+	if err != nil {
+		return nil, err
+	}
+	fs.lock, dd.lock = dd.lock, nil
+	return fs, nil
+}
+
+func openFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		fs.state, fs.err = decodeFileState(b)
+		if fs.err != nil {
+			fs.err = fmt.Errorf("%s: %w", path, fs.err)
+		}
+	}
+	return fs, nil
+}
+
+// Close releases the lock on the directory.
+func (fs *FileStore) Close() error {
+	if fs.lock == nil {
+		return nil
+	}
+	return fs.lock.Close()
+}
+
+// Service returns the FileStore keeping the state of a tracked service, a file of the same name in a
+// directory below fs that is created if it does not exist.
+func (fs *FileStore) Service(name string) (*FileStore, error) {
+	err := ValidateServiceName(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(filepath.Dir(fs.path), "services", name)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create datadir of service %s: %w", name, err)
+	}
+	return openFileStore(filepath.Join(dir, filepath.Base(fs.path)))
+}
+
+func (fs *FileStore) ServiceStore(name string) (DataStore, error) {
+	return fs.Service(name)
+}
+
+func (fs *FileStore) SetStamp(t time.Time) error {
+	return fs.update(func(s *fileState) {
+		s.stamp = t.UnixNano()
+		s.present |= presentStamp
+	})
+}
+
+func (fs *FileStore) GetStamp() (time.Time, error) {
+	return fs.getTime("stamp", presentStamp, func(s fileState) int64 { return s.stamp })
+}
+
+func (fs *FileStore) SetShutdown(t time.Time) error {
+	return fs.update(func(s *fileState) {
+		s.shutdown = t.UnixNano()
+		s.present |= presentShutdown
+	})
+}
+
+func (fs *FileStore) GetShutdown() (time.Time, error) {
+	return fs.getTime("shutdown", presentShutdown, func(s fileState) int64 { return s.shutdown })
+}
+
+func (fs *FileStore) SetBoot(t time.Time) error {
+	return fs.update(func(s *fileState) {
+		s.boot = t.UnixNano()
+		s.present |= presentBoot
+	})
+}
+
+func (fs *FileStore) GetBoot() (time.Time, error) {
+	return fs.getTime("boot", presentBoot, func(s fileState) int64 { return s.boot })
+}
+
+func (fs *FileStore) SetMonitorStop(t time.Time) error {
+	return fs.update(func(s *fileState) {
+		s.monitorStop = t.UnixNano()
+		s.present |= presentMonitorStop
+	})
+}
+
+func (fs *FileStore) GetMonitorStop() (time.Time, error) {
+	return fs.getTime("monitor stop", presentMonitorStop, func(s fileState) int64 { return s.monitorStop })
+}
+
+func (fs *FileStore) SetBootID(id BootID) error {
+	return fs.update(func(s *fileState) {
+		s.bootID = id
+		s.present |= presentBootID
+	})
+}
+
+func (fs *FileStore) GetBootID() (BootID, error) {
+	s, err := fs.get("boot ID", presentBootID)
+	return s.bootID, err
+}
+
+func (fs *FileStore) SetShutdownKind(kind ShutdownKind) error {
+	return fs.update(func(s *fileState) {
+		s.kind = kind
+		s.present |= presentShutdownKind
+	})
+}
+
+func (fs *FileStore) GetShutdownKind() (ShutdownKind, error) {
+	s, err := fs.get("shutdown kind", presentShutdownKind)
+	if err != nil {
+		return ShutdownKindUnknown, err
+	}
+	return s.kind, nil
+}
+
+// get returns the state if the field is present, an error wrapping os.ErrNotExist if not
+func (fs *FileStore) get(field string, present uint8) (fileState, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return fileState{}, fs.err
+	}
+	if fs.state.present&present == 0 {
+		return fileState{}, fmt.Errorf("no %s in %s: %w", field, fs.path, os.ErrNotExist)
+	}
+	return fs.state, nil
+}
+
+func (fs *FileStore) getTime(field string, present uint8, value func(fileState) int64) (time.Time, error) {
+	s, err := fs.get(field, present)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, value(s)), nil
+}
+
+// update changes the state and replaces the file with it, the state is unchanged if that fails
+func (fs *FileStore) update(change func(*fileState)) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	state := fs.state
+	if fs.err != nil {
+		// the file was unreadable, start over
+		state = fileState{}
+	}
+	change(&state)
+	err := writeFileAtomic(fs.path, encodeFileState(state))
+	if err != nil {
+		return err
+	}
+	fs.state = state
+	fs.err = nil
+	return nil
+}
+
+// empty is true if nothing was ever stored
+func (fs *FileStore) empty() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.err == nil && fs.state.present == 0
+}
+
+func encodeFileState(s fileState) []byte {
+	b := make([]byte, fileStoreSize)
+	copy(b, fileStoreMagic)
+	b[4] = fileStoreVersion
+	b[5] = uint8(s.kind)
+	b[6] = s.present
+	binary.BigEndian.PutUint64(b[16:24], uint64(s.stamp))
+	binary.BigEndian.PutUint64(b[24:32], uint64(s.shutdown))
+	binary.BigEndian.PutUint64(b[32:40], uint64(s.boot))
+	binary.BigEndian.PutUint64(b[40:48], uint64(s.monitorStop))
+	copy(b[48:], s.bootID[:])
+	binary.BigEndian.PutUint32(b[8:12], crc32.ChecksumIEEE(b))
+	return b
+}
+
+func decodeFileState(b []byte) (fileState, error) {
+	if len(b) != fileStoreSize || !bytes.Equal(b[:4], []byte(fileStoreMagic)) {
+		return fileState{}, fmt.Errorf("%w: not a state file", ErrInvalidRecord)
+	}
+	if b[4] != fileStoreVersion {
+		return fileState{}, fmt.Errorf("%w: unknown state file version %d", ErrInvalidRecord, b[4])
+	}
+	sum := binary.BigEndian.Uint32(b[8:12])
+	unsummed := make([]byte, len(b))
+	copy(unsummed, b)
+	binary.BigEndian.PutUint32(unsummed[8:12], 0)
+	if crc32.ChecksumIEEE(unsummed) != sum {
+		return fileState{}, ErrChecksum
+	}
+	s := fileState{
+		kind:        ShutdownKind(b[5]),
+		present:     b[6],
+		stamp:       int64(binary.BigEndian.Uint64(b[16:24])),
+		shutdown:    int64(binary.BigEndian.Uint64(b[24:32])),
+		boot:        int64(binary.BigEndian.Uint64(b[32:40])),
+		monitorStop: int64(binary.BigEndian.Uint64(b[40:48])),
+	}
+	copy(s.bootID[:], b[48:])
+	return s, nil
+}
+
+// writeFileAtomic replaces the file at path with b, so it either has the old or the new content after a crash
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	// the rename itself is only durable once the directory is synced, not every system can
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	dir.Sync()
+	dir.Close()
+	return nil
+}
+
+/*
+MigrateDataDir copies the state kept by a DataDir into an empty FileStore, including the state of the
+services below it, so switching stores does not lose track of the last outage. It returns false without
+changing anything if fs already has state. The files of the DataDir are left behind.
+*/
+func MigrateDataDir(dd *DataDir, fs *FileStore) (bool, error) {
+	if !fs.empty() {
+		return false, nil
+	}
+	migrated, err := migrateState(dd, fs)
+	if err != nil {
+		return false, fmt.Errorf("migrating %s: %w", dd.dir, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dd.dir, "services"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return migrated, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || ValidateServiceName(entry.Name()) != nil {
+			continue
+		}
+		from, err := dd.Service(entry.Name())
+		if err != nil {
+			return migrated, err
+		}
+		to, err := fs.Service(entry.Name())
+		if err != nil {
+			return migrated, err
+		}
+		if !to.empty() {
+			continue
+		}
+		ok, err := migrateState(from, to)
+		if err != nil {
+			return migrated, fmt.Errorf("migrating %s: %w", from.dir, err)
+		}
+		migrated = migrated || ok
+	}
+	return migrated, nil
+}
+
+// migrateState copies every value dd has to fs in one update
+func migrateState(dd *DataDir, fs *FileStore) (bool, error) {
+	var state fileState
+	times := []struct {
+		get     func() (time.Time, error)
+		value   *int64
+		present uint8
+	}{
+		{dd.GetStamp, &state.stamp, presentStamp},
+		{dd.GetShutdown, &state.shutdown, presentShutdown},
+		{dd.GetBoot, &state.boot, presentBoot},
+		{dd.GetMonitorStop, &state.monitorStop, presentMonitorStop},
+	}
+	for _, t := range times {
+		when, err := t.get()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		*t.value = when.UnixNano()
+		state.present |= t.present
+	}
+	id, err := dd.GetBootID()
+	if err == nil {
+		state.bootID = id
+		state.present |= presentBootID
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	kind, err := dd.GetShutdownKind()
+	if err == nil {
+		state.kind = kind
+		state.present |= presentShutdownKind
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if state.present == 0 {
+		return false, nil
+	}
+	return true, fs.update(func(s *fileState) { *s = state })
+}
@@ -0,0 +1,125 @@
+package downtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, downtime.DefaultStateFile)
+	fs, err := downtime.NewFileStore(path)
+	require.NoError(t, err)
+
+	_, err = fs.GetStamp()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fs.GetBootID()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	stamp := time.Unix(1633484567, 123456789)
+	require.NoError(t, fs.SetStamp(stamp))
+	require.NoError(t, fs.SetBoot(stamp.Add(-time.Hour)))
+	require.NoError(t, fs.SetShutdownKind(downtime.ShutdownKindPowerFailure))
+	id, err := downtime.ParseBootID("0f0e0d0c-0b0a-0908-0706-050403020100")
+	require.NoError(t, err)
+	require.NoError(t, fs.SetBootID(id))
+
+	_, err = downtime.NewFileStore(path)
+	assert.ErrorIs(t, err, downtime.ErrLocked)
+	require.NoError(t, fs.Close())
+
+	reopened, err := downtime.NewFileStore(path)
+	require.NoError(t, err)
+	got, err := reopened.GetStamp()
+	require.NoError(t, err)
+	assert.True(t, stamp.Equal(got), "nanoseconds are kept: %s", got)
+	got, err = reopened.GetBoot()
+	require.NoError(t, err)
+	assert.True(t, stamp.Add(-time.Hour).Equal(got))
+	_, err = reopened.GetShutdown()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	kind, err := reopened.GetShutdownKind()
+	require.NoError(t, err)
+	assert.Equal(t, downtime.ShutdownKindPowerFailure, kind)
+	gotID, err := reopened.GetBootID()
+	require.NoError(t, err)
+	assert.Equal(t, id, gotID)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind, only the lock")
+	require.NoError(t, reopened.Close())
+
+	// a damaged file is reported until it is replaced
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	b[20] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	damaged, err := downtime.NewFileStore(path)
+	require.NoError(t, err)
+	defer damaged.Close()
+	_, err = damaged.GetStamp()
+	assert.ErrorIs(t, err, downtime.ErrChecksum)
+	require.NoError(t, damaged.SetStamp(stamp))
+	got, err = damaged.GetStamp()
+	require.NoError(t, err)
+	assert.True(t, stamp.Equal(got))
+	_, err = damaged.GetBoot()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = downtime.NewFileStore(filepath.Join(dir, "missing", downtime.DefaultStateFile))
+	assert.Error(t, err)
+}
+
+func TestMigrateDataDir(t *testing.T) {
+	dir := t.TempDir()
+	dd, err := downtime.NewDataDir(dir)
+	require.NoError(t, err)
+	stamp := time.Unix(1633484567, 0)
+	require.NoError(t, dd.SetStamp(stamp))
+	require.NoError(t, dd.SetBoot(stamp.Add(-time.Hour)))
+	require.NoError(t, dd.SetShutdownKind(downtime.ShutdownKindReboot))
+	api, err := dd.Service("api")
+	require.NoError(t, err)
+	require.NoError(t, api.SetStamp(stamp.Add(time.Minute)))
+
+	fs, err := dd.FileStore()
+	require.NoError(t, err)
+	defer fs.Close()
+	require.NoError(t, dd.Close(), "the FileStore took over the lock")
+	migrated, err := downtime.MigrateDataDir(dd, fs)
+	require.NoError(t, err)
+	assert.True(t, migrated)
+
+	got, err := fs.GetStamp()
+	require.NoError(t, err)
+	assert.True(t, stamp.Equal(got))
+	got, err = fs.GetBoot()
+	require.NoError(t, err)
+	assert.True(t, stamp.Add(-time.Hour).Equal(got))
+	_, err = fs.GetShutdown()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	kind, err := fs.GetShutdownKind()
+	require.NoError(t, err)
+	assert.Equal(t, downtime.ShutdownKindReboot, kind)
+	fsAPI, err := fs.Service("api")
+	require.NoError(t, err)
+	got, err = fsAPI.GetStamp()
+	require.NoError(t, err)
+	assert.True(t, stamp.Add(time.Minute).Equal(got))
+
+	// only once
+	require.NoError(t, dd.SetStamp(stamp.Add(time.Hour)))
+	migrated, err = downtime.MigrateDataDir(dd, fs)
+	require.NoError(t, err)
+	assert.False(t, migrated)
+	got, err = fs.GetStamp()
+	require.NoError(t, err)
+	assert.True(t, stamp.Equal(got))
+}
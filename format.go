@@ -0,0 +1,260 @@
+package downtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+const (
+	// DatabaseMagic identifies a versioned downtime database, it is the first thing in the file.
+	DatabaseMagic = "DTDB"
+	// DatabaseVersion is the format version written by DatabaseWriter.
+	DatabaseVersion = 2
+	// HeaderSize is the size of the header preceding the records of a versioned database.
+	HeaderSize = 16
+)
+
+// LegacyVersion is reported for databases written before the header was introduced.
+const LegacyVersion = 1
+
+var (
+	ErrNotDatabase        = errors.New("not a downtime database")
+	ErrUnsupportedVersion = errors.New("unsupported database version")
+	ErrInvalidRecord      = errors.New("invalid record")
+	ErrChecksum           = errors.New("record checksum mismatch")
+	ErrTruncated          = errors.New("truncated record")
+)
+
+// RecordError reports a record that could not be read along with its byte offset in the database.
+type RecordError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+/*
+header is the on disk layout of the database header:
+
+	magic       [4]byte  "DTDB"
+	version     uint16   format version
+	record size uint16   size of each record following the header
+	reserved    [8]byte  zero
+*/
+type header struct {
+	Magic      [4]byte
+	Version    uint16
+	RecordSize uint16
+	_          [8]uint8 // reserved
+}
+
+func newHeader(format *recordFormat) header {
+	h := header{
+		Version:    format.version,
+		RecordSize: uint16(format.size),
+	}
+	copy(h.Magic[:], DatabaseMagic)
+	return h
+}
+
+func (h header) encode() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, HeaderSize))
+	// writing to a bytes.Buffer can not fail
+	_ = binary.Write(buf, binary.BigEndian, h)
+	return buf.Bytes()
+}
+
+func decodeHeader(b []byte) (header, error) {
+	var h header
+	if len(b) < HeaderSize || string(b[:len(DatabaseMagic)]) != DatabaseMagic {
+		return h, ErrNotDatabase
+	}
+	err := binary.Read(bytes.NewReader(b[:HeaderSize]), binary.BigEndian, &h)
+	return h, err
+}
+
+// recordFormat describes how events are laid out on disk for a given database version
+type recordFormat struct {
+	version uint16
+	size    int
+	encode  func(Event) []byte
+	decode  func([]byte) (Event, error)
+}
+
+var (
+	legacyFormat = &recordFormat{
+		version: LegacyVersion,
+		size:    16,
+		encode:  encodeRecordV1,
+		decode:  decodeRecordV1,
+	}
+	currentFormat = &recordFormat{
+		version: DatabaseVersion,
+		size:    EventSize,
+		encode:  encodeRecord,
+		decode:  decodeRecord,
+	}
+)
+
+var recordFormats = map[uint16]*recordFormat{
+	legacyFormat.version:  legacyFormat,
+	currentFormat.version: currentFormat,
+}
+
+func (f *recordFormat) size64() int64 {
+	return int64(f.size)
+}
+
+func lookupFormat(h header) (*recordFormat, error) {
+	format, ok := recordFormats[h.Version]
+	if !ok || h.Version == LegacyVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	if int(h.RecordSize) != format.size {
+		return nil, fmt.Errorf("%w: version %d records are %d bytes, header says %d", ErrNotDatabase, h.Version, format.size, h.RecordSize)
+	}
+	return format, nil
+}
+
+/*
+Legacy records, written before the header was introduced, are 16 bytes:
+
+	type     uint8
+	padding  [7]byte  zero
+	when     int64    seconds since the unix epoch
+*/
+func encodeRecordV1(event Event) []byte {
+	b := make([]byte, 16)
+	b[0] = uint8(event.What)
+	binary.BigEndian.PutUint64(b[8:], uint64(int64(event.When)/int64(time.Second)))
+	return b
+}
+
+func decodeRecordV1(b []byte) (Event, error) {
+	event := Event{
+		What: EventType(b[0]),
+		When: UnixTimestamp(int64(binary.BigEndian.Uint64(b[8:])) * int64(time.Second)),
+	}
+	if !zero(b[1:8]) {
+		return event, fmt.Errorf("%w: non-zero padding", ErrInvalidRecord)
+	}
+	return event, validateRecord(event)
+}
+
+/*
+record is the on disk layout of version 2 records:
+
+	type     uint8
+	kind     uint8    ShutdownKind of Shutdown events, zero otherwise
+	padding  [2]byte  zero
+	crc      uint32   IEEE CRC-32 of the record with this field zeroed
+	when     int64    nanoseconds since the unix epoch
+	offset   int64    nanoseconds the clock was stepped by for ClockJump events, zero otherwise
+	boot id  [16]byte ID of the boot that began with an Up event, zero otherwise
+	service  [32]byte name of the tracked service padded with zeros, all zero for the system
+*/
+type record struct {
+	What    uint8
+	Kind    uint8
+	Padding [2]uint8
+	CRC     uint32
+	When    int64
+	Offset  int64
+	BootID  [16]byte
+	Service [32]byte
+}
+
+func encodeRecord(event Event) []byte {
+	r := record{
+		What:   uint8(event.What),
+		Kind:   uint8(event.Kind),
+		When:   int64(event.When),
+		Offset: int64(event.Offset),
+		BootID: event.BootID,
+	}
+	copy(r.Service[:], event.Service)
+	buf := bytes.NewBuffer(make([]byte, 0, EventSize))
+	// writing to a bytes.Buffer can not fail
+	_ = binary.Write(buf, binary.BigEndian, r)
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b))
+	return b
+}
+
+func decodeRecord(b []byte) (Event, error) {
+	unsummed, err := verifyChecksum(b)
+	if err != nil {
+		return Event{}, err
+	}
+	var r record
+	err = binary.Read(bytes.NewReader(unsummed), binary.BigEndian, &r)
+	if err != nil {
+		return Event{}, err
+	}
+	service := bytes.TrimRight(r.Service[:], "\x00")
+	event := Event{
+		What:    EventType(r.What),
+		Kind:    ShutdownKind(r.Kind),
+		When:    UnixTimestamp(r.When),
+		Offset:  time.Duration(r.Offset),
+		BootID:  r.BootID,
+		Service: string(service),
+	}
+	switch {
+	case !zero(r.Padding[:]):
+		return event, fmt.Errorf("%w: non-zero padding", ErrInvalidRecord)
+	case bytes.IndexByte(service, 0) != -1:
+		return event, fmt.Errorf("%w: service name contains zero bytes", ErrInvalidRecord)
+	case !validShutdownKind(event.Kind):
+		return event, fmt.Errorf("%w: unknown shutdown kind %d", ErrInvalidRecord, r.Kind)
+	}
+	return event, validateRecord(event)
+}
+
+// verifyChecksum checks the CRC-32 at bytes 4 to 8 and returns a copy of the record with it zeroed
+func verifyChecksum(b []byte) ([]byte, error) {
+	sum := binary.BigEndian.Uint32(b[4:8])
+	unsummed := make([]byte, len(b))
+	copy(unsummed, b)
+	binary.BigEndian.PutUint32(unsummed[4:8], 0)
+	if crc32.ChecksumIEEE(unsummed) != sum {
+		return nil, ErrChecksum
+	}
+	return unsummed, nil
+}
+
+func zero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func validateRecord(event Event) error {
+	if !validEventType(event.What) {
+		return fmt.Errorf("%w: unknown event type %d", ErrInvalidRecord, uint8(event.What))
+	}
+	return nil
+}
+
+func validShutdownKind(kind ShutdownKind) bool {
+	_, ok := _ShutdownKindMap[kind]
+	return ok
+}
+
+func validEventType(what EventType) bool {
+	_, ok := _EventTypeMap[what]
+	return ok && what != EventTypeNone
+}
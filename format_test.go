@@ -0,0 +1,46 @@
+package downtime
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordLayout(t *testing.T) {
+	id, err := ParseBootID("0f0e0d0c-0b0a-0908-0706-050403020100")
+	require.NoError(t, err)
+	event := NewEvent(EventTypeShutdown, time.Unix(1633484567, 123456789))
+	event.Kind = ShutdownKindPowerFailure
+	event.Offset = -time.Minute
+	event.BootID = id
+	event.Service = "api"
+
+	b := encodeRecord(event)
+	require.Len(t, b, EventSize)
+	assert.Equal(t, uint8(EventTypeShutdown), b[0])
+	assert.Equal(t, uint8(ShutdownKindPowerFailure), b[1])
+	assert.Equal(t, []byte{0, 0}, b[2:4])
+	assert.Equal(t, uint64(event.When), binary.BigEndian.Uint64(b[8:16]))
+	assert.Equal(t, event.Offset, time.Duration(binary.BigEndian.Uint64(b[16:24])))
+	assert.Equal(t, id[:], b[24:40])
+	assert.Equal(t, "api", string(b[40:43]))
+	assert.Equal(t, make([]byte, 29), b[43:])
+	sum := binary.BigEndian.Uint32(b[4:8])
+	binary.BigEndian.PutUint32(b[4:8], 0)
+	assert.Equal(t, crc32.ChecksumIEEE(b), sum)
+	binary.BigEndian.PutUint32(b[4:8], sum)
+
+	decoded, err := decodeRecord(b)
+	require.NoError(t, err)
+	assert.Equal(t, event, decoded)
+
+	b[2] = 1
+	binary.BigEndian.PutUint32(b[4:8], 0)
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b))
+	_, err = decodeRecord(b)
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+}
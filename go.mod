@@ -8,6 +8,7 @@ require (
 	github.com/lestrrat-go/strftime v1.0.5
 	github.com/prometheus/procfs v0.7.3
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
 )
 
 require (
@@ -18,6 +19,5 @@ require (
 	github.com/mattn/go-isatty v0.0.0-20160806122752-66b8e73f3f5c // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
@@ -0,0 +1,100 @@
+package downtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHookTimeout is how long an ExecHook may run before it is killed.
+const DefaultHookTimeout = 30 * time.Second
+
+// NewExecHook runs command with /bin/sh, killing it if it takes longer than timeout.
+func NewExecHook(command string, timeout time.Duration) *ExecHook {
+	return &ExecHook{
+		command: command,
+		timeout: timeout,
+	}
+}
+
+/*
+ExecHook is a Hook running an external command. The outage is described to the command by
+these environment variables, times are RFC 3339 and durations are in seconds:
+
+	DOWNTIME_EVENT     Crash, Shutdown or unknown if how the system went down is not known
+	DOWNTIME_DOWN      when the system went down, not set if unknown
+	DOWNTIME_UP        when the system came back up
+	DOWNTIME_UPTIME    how long the system was up before the outage
+	DOWNTIME_DOWNTIME  how long the outage lasted
+	DOWNTIME_KIND      reboot, poweroff, halt, power-failure or unknown, for shutdowns only
+*/
+type ExecHook struct {
+	command string
+	timeout time.Duration
+}
+
+func (h *ExecHook) Run(report OutageReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	// collect the output in a file rather than a pipe, so children of the command that
+	// outlive it can not keep us waiting for the output to be closed after a timeout
+	output, err := os.CreateTemp("", "downtime-hook-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	cmd.Env = append(os.Environ(), hookEnv(report)...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	start := time.Now()
+	err = cmd.Run()
+	logged, _ := os.ReadFile(output.Name())
+	for _, line := range strings.Split(strings.TrimSpace(string(logged)), "\n") {
+		if line != "" {
+			logger.Infof("%s: %s", h.command, line)
+		}
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%s timed out after %s", h.command, h.timeout)
+	case errors.As(err, &exitErr):
+		return fmt.Errorf("%s exited with status %d", h.command, exitErr.ExitCode())
+	case err != nil:
+		return fmt.Errorf("%s: %w", h.command, err)
+	}
+	logger.Infof("%s exited with status 0 after %s", h.command, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func hookEnv(report OutageReport) []string {
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	env := []string{
+		"DOWNTIME_UP=" + report.Up.When.AsTime().Format(time.RFC3339Nano),
+		"DOWNTIME_UPTIME=" + seconds(report.PreviousUptime),
+		"DOWNTIME_DOWNTIME=" + seconds(report.Downtime),
+	}
+	if report.Down.What == EventTypeNone {
+		return append(env, "DOWNTIME_EVENT=unknown")
+	}
+	env = append(env,
+		"DOWNTIME_EVENT="+report.Down.What.String(),
+		"DOWNTIME_DOWN="+report.Down.When.AsTime().Format(time.RFC3339Nano),
+	)
+	if report.Down.What == EventTypeShutdown {
+		env = append(env, "DOWNTIME_KIND="+report.Down.Kind.String())
+	}
+	return env
+}
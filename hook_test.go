@@ -0,0 +1,102 @@
+package downtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHook struct {
+	reports []OutageReport
+}
+
+func (h *recordingHook) Run(report OutageReport) error {
+	h.reports = append(h.reports, report)
+	return nil
+}
+
+func TestDaemonHooks(t *testing.T) {
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	d := NewDaemonWithClock(store, NewDatabaseWriter(bytes.NewBuffer([]byte{})), DefaultSleepSeconds*time.Second, clk)
+	crashes, shutdowns, ups := new(recordingHook), new(recordingHook), new(recordingHook)
+	d.AddHook(EventTypeCrash, crashes)
+	d.AddHook(EventTypeShutdown, shutdowns)
+	d.AddHook(EventTypeUp, ups)
+
+	store.boot = boot
+	clk.Set(boot.Add(time.Hour))
+	d.stamp(false)
+	assert.NoError(t, d.Init(boot.Add(time.Hour+time.Minute), time.Stamp))
+
+	assert.Empty(t, shutdowns.reports)
+	if assert.Len(t, crashes.reports, 1) {
+		report := crashes.reports[0]
+		assert.True(t, report.Crashed())
+		assert.Equal(t, time.Hour, report.PreviousUptime)
+		assert.Equal(t, time.Minute, report.Downtime)
+		assert.Equal(t, NewEvent(EventTypeUp, boot.Add(time.Hour+time.Minute)), report.Up)
+	}
+	assert.Equal(t, crashes.reports, ups.reports)
+}
+
+func TestDaemonUpHookUnknownDowntime(t *testing.T) {
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	d := NewDaemonWithClock(store, NewDatabaseWriter(bytes.NewBuffer([]byte{})), DefaultSleepSeconds*time.Second, clk)
+	crashes, ups := new(recordingHook), new(recordingHook)
+	d.AddHook(EventTypeCrash, crashes)
+	d.AddHook(EventTypeUp, ups)
+
+	store.boot = boot
+	clk.Set(boot.Add(time.Hour))
+	d.stamp(false)
+	// the clock came back behind the last stamp
+	assert.NoError(t, d.Init(boot.Add(time.Minute), time.Stamp))
+
+	assert.Empty(t, crashes.reports)
+	if assert.Len(t, ups.reports, 1) {
+		report := ups.reports[0]
+		assert.Equal(t, EventTypeNone, report.Down.What)
+		assert.Equal(t, NewEvent(EventTypeUp, boot.Add(time.Minute)), report.Up)
+		assert.Contains(t, hookEnv(report), "DOWNTIME_EVENT=unknown")
+	}
+}
+
+func TestExecHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	report := OutageReport{
+		Outage: Outage{
+			Down: NewEvent(EventTypeCrash, time.Date(2021, time.October, 6, 1, 2, 3, 0, time.UTC)),
+			Up:   NewEvent(EventTypeUp, time.Date(2021, time.October, 6, 1, 3, 3, 0, time.UTC)),
+		},
+		PreviousUptime: 90 * time.Minute,
+		Downtime:       time.Minute,
+	}
+
+	hook := NewExecHook("env | grep ^DOWNTIME_ | sort > "+out, DefaultHookTimeout)
+	assert.NoError(t, hook.Run(report))
+	env, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DOWNTIME_DOWN=" + report.Down.When.AsTime().Format(time.RFC3339Nano),
+		"DOWNTIME_DOWNTIME=60",
+		"DOWNTIME_EVENT=Crash",
+		"DOWNTIME_UP=" + report.Up.When.AsTime().Format(time.RFC3339Nano),
+		"DOWNTIME_UPTIME=5400",
+	}, strings.Fields(string(env)))
+
+	err = NewExecHook("exit 3", DefaultHookTimeout).Run(report)
+	assert.EqualError(t, err, "exit 3 exited with status 3")
+
+	err = NewExecHook("sleep 5", 100*time.Millisecond).Run(report)
+	assert.EqualError(t, err, "sleep 5 timed out after 100ms")
+}
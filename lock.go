@@ -0,0 +1,82 @@
+package downtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when a data directory is already in use by another process.
+var ErrLocked = errors.New("locked by another process")
+
+// lockFileName is the file in a data directory holding the lock, and the PID of the process holding it
+const lockFileName = "downtimed.lock"
+
+// lockDataDir takes the exclusive lock on dir, it is held until the returned file is closed
+func lockDataDir(dir string) (*os.File, error) {
+	f, err := lockExclusive(filepath.Join(dir, lockFileName), dir)
+	if err != nil && !errors.Is(err, ErrLocked) {
+		return nil, fmt.Errorf("unable to lock datadir: %w", err)
+	}
+	return f, err
+}
+
+// lockExclusive takes the exclusive lock on the lock file at path, which holds the PID of the process
+// holding it, on behalf of what is named in the error if it is held elsewhere
+func lockExclusive(path, name string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = tryLockFile(f)
+	if errors.Is(err, ErrLocked) {
+		holder := "another process"
+		b, _ := io.ReadAll(f)
+		if pid := strings.TrimSpace(string(b)); pid != "" {
+			holder = "process " + pid
+		}
+		f.Close()
+		return nil, fmt.Errorf("%s is in use by %s: %w", name, holder, err)
+	}
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// sharedFile takes a shared lock on the file for every read and for finding its end, so it never sees
+// half of a record that is being appended
+type sharedFile struct {
+	*os.File
+}
+
+func (f sharedFile) Read(p []byte) (int, error) {
+	err := lockFile(f.File, false)
+	if err != nil {
+		return 0, err
+	}
+	defer unlockFile(f.File)
+	return f.File.Read(p)
+}
+
+func (f sharedFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		err := lockFile(f.File, false)
+		if err != nil {
+			return 0, err
+		}
+		defer unlockFile(f.File)
+	}
+	return f.File.Seek(offset, whence)
+}
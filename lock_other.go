@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package downtime
+
+import "os"
+
+// files are not locked on this platform
+
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
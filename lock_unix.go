@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package downtime
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile waits for a shared or exclusive advisory lock on f, it is released by unlockFile or closing f
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return flock(f, how)
+}
+
+// tryLockFile takes an exclusive advisory lock on f, or fails with ErrLocked if it is held elsewhere
+func tryLockFile(f *os.File) error {
+	err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return flock(f, syscall.LOCK_UN)
+}
+
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
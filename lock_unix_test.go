@@ -0,0 +1,163 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package downtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataDirLock(t *testing.T) {
+	dir := t.TempDir()
+	dd, err := NewDataDir(dir)
+	require.NoError(t, err)
+
+	_, err = NewDataDir(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.Contains(t, err.Error(), fmt.Sprintf("process %d", os.Getpid()))
+	_, err = NewFileStore(filepath.Join(dir, DefaultStateFile))
+	assert.ErrorIs(t, err, ErrLocked)
+
+	api, err := dd.Service("api")
+	require.NoError(t, err, "services share the lock")
+	require.NoError(t, api.Close())
+	_, err = NewDataDir(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, dd.Close())
+	dd, err = NewDataDir(dir)
+	require.NoError(t, err)
+
+	// the lock is handed over to the FileStore
+	fs, err := dd.FileStore()
+	require.NoError(t, err)
+	require.NoError(t, dd.Close())
+	_, err = NewDataDir(dir)
+	assert.ErrorIs(t, err, ErrLocked, "closing the DataDir does not release the lock")
+	require.NoError(t, fs.Close())
+	dd, err = NewDataDir(dir)
+	require.NoError(t, err)
+	require.NoError(t, dd.Close())
+}
+
+func TestDatabaseLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultDBFile)
+	w, err := OpenDatabaseWriter(path)
+	require.NoError(t, err)
+	defer w.Close()
+	first := NewEvent(EventTypeUp, time.Unix(1633484567, 0))
+	require.NoError(t, w.Append(first))
+
+	_, err = OpenDatabaseWriter(path)
+	assert.ErrorIs(t, err, ErrLocked, "only one writer")
+	assert.Contains(t, err.Error(), fmt.Sprintf("process %d", os.Getpid()))
+
+	r, err := OpenDatabaseReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	second := NewEvent(EventTypeShutdown, time.Unix(1633484667, 0))
+	require.NoError(t, w.Append(second), "an open reader does not hold up appends")
+
+	// an append in progress holds the exclusive lock
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, lockFile(f, true))
+	read := make(chan []Event)
+	go func() {
+		events, _ := r.All()
+		read <- events
+	}()
+	select {
+	case <-read:
+		t.Fatal("read during an append")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, unlockFile(f))
+	select {
+	case events := <-read:
+		assert.Equal(t, []Event{first, second}, events)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not continue after the append")
+	}
+
+	require.NoError(t, w.Close())
+	w, err = OpenDatabaseWriter(path)
+	require.NoError(t, err, "the lock is released by Close")
+	require.NoError(t, w.Close())
+}
+
+func TestRewriteDatabaseLocksOutAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultDBFile)
+	w, err := OpenDatabaseWriter(path)
+	require.NoError(t, err)
+	defer w.Close()
+	old := NewEvent(EventTypeUp, time.Unix(1633484567, 0))
+	require.NoError(t, w.Append(old))
+
+	appended := make(chan error)
+	late := NewEvent(EventTypeShutdown, time.Unix(1633484667, 0))
+	err = rewriteDatabase(path, nil, func(events []Event) ([]Event, error) {
+		// the daemon appends while the database is being rotated
+		go func() {
+			appended <- w.Append(late)
+		}()
+		select {
+		case <-appended:
+			t.Error("appended during the rewrite")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return []Event{}, nil
+	})
+	require.NoError(t, err)
+	select {
+	case err := <-appended:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("append did not continue after the rewrite")
+	}
+
+	r, err := OpenDatabaseReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	events, err := r.All()
+	require.NoError(t, err)
+	assert.Equal(t, []Event{late}, events, "the append went to the new file")
+}
+
+func TestUpgradeDatabaseLocks(t *testing.T) {
+	legacy, err := os.ReadFile("./test.db")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), DefaultDBFile)
+	require.NoError(t, os.WriteFile(path, legacy, 0644))
+
+	// an append in progress holds the exclusive lock
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, lockFile(f, true))
+	upgraded := make(chan error)
+	go func() {
+		_, err := UpgradeDatabase(path)
+		upgraded <- err
+	}()
+	select {
+	case <-upgraded:
+		t.Fatal("upgraded during an append")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, unlockFile(f))
+	select {
+	case err := <-upgraded:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrade did not continue after the append")
+	}
+}
@@ -0,0 +1,233 @@
+package downtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Metrics exports the downtime history and the state of a daemon in the Prometheus text format.
+The history is read once by Load and kept up to date by appending events, so Metrics is usually
+one of the sinks of a FanOutWriter, and Watch follows the daemon for everything else.
+*/
+type Metrics struct {
+	mu            sync.Mutex
+	events        map[EventType]uint64
+	downtime      time.Duration
+	suspended     time.Duration
+	lastOutage    time.Duration
+	down          Event
+	outages       []Outage
+	stampFailures uint64
+	daemon        *Daemon
+}
+
+const day = 24 * time.Hour
+
+// AvailabilityWindows are the periods before now that availability is exported for.
+var AvailabilityWindows = []time.Duration{7 * day, 30 * day, 90 * day}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		events: map[EventType]uint64{
+			EventTypeCrash:    0,
+			EventTypeShutdown: 0,
+			EventTypeUp:       0,
+			EventTypeSuspend:  0,
+			EventTypeResume:   0,
+		},
+	}
+}
+
+// Load adds all events of r.
+func (m *Metrics) Load(r EventReader) error {
+	err := r.Reset()
+	if err != nil {
+		return err
+	}
+	for {
+		event, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m.Append(event)
+	}
+}
+
+// Append adds an event, it never fails. Events of tracked services are left out, the metrics are about the system.
+func (m *Metrics) Append(event Event) error {
+	if event.Service != "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[event.What]++
+	switch {
+	case outageEnd(event.What) != EventTypeNone:
+		m.down = event
+	case isOutageEnd(event.What) && m.down.What != EventTypeNone:
+		outage := Outage{Down: m.down, Up: event}
+		m.down = Event{}
+		if outageEnd(outage.Down.What) != event.What {
+			break
+		}
+		if outage.Suspended() {
+			m.suspended += outage.Duration()
+		} else {
+			m.downtime += outage.Duration()
+			m.lastOutage = outage.Duration()
+		}
+		m.outages = append(m.outages, outage)
+	}
+	return nil
+}
+
+func (m *Metrics) Close() error {
+	return nil
+}
+
+// Watch exports the boot and stamp times of d and counts its failures to update the stamp.
+func (m *Metrics) Watch(d *Daemon) (unwatch func()) {
+	m.mu.Lock()
+	m.daemon = d
+	m.mu.Unlock()
+	unsubscribe := d.Subscribe(func(n Notification) {
+		if n.Kind == NotifyStampFailed {
+			m.mu.Lock()
+			m.stampFailures++
+			m.mu.Unlock()
+		}
+	})
+	return func() {
+		unsubscribe()
+		m.mu.Lock()
+		m.daemon = nil
+		m.mu.Unlock()
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	types := make([]EventType, 0, len(m.events))
+	for what := range m.events {
+		types = append(types, what)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].String() < types[j].String() })
+	counts := make([]uint64, len(types))
+	for i, what := range types {
+		counts[i] = m.events[what]
+	}
+	downtime, suspended, lastOutage, stampFailures, d := m.downtime, m.suspended, m.lastOutage, m.stampFailures, m.daemon
+	now := time.Now()
+	if d != nil {
+		now = d.clk.Now()
+	}
+	availability := make([]float64, len(AvailabilityWindows))
+	for i, window := range AvailabilityWindows {
+		availability[i] = Availability(m.outages, now.Add(-window), now)
+	}
+	m.mu.Unlock()
+
+	pw := &promWriter{w: w}
+	pw.header("downtime_events_total", "counter", "Events recorded in the downtime database by type.")
+	for i, what := range types {
+		pw.sample(fmt.Sprintf("downtime_events_total{type=%q}", what), float64(counts[i]))
+	}
+	pw.metric("downtime_downtime_seconds_total", "counter", "Total duration of all complete outages, not counting suspends.", downtime.Seconds())
+	pw.metric("downtime_suspended_seconds_total", "counter", "Total time the system was suspended.", suspended.Seconds())
+	pw.metric("downtime_last_outage_duration_seconds", "gauge", "Duration of the most recent complete outage, not counting suspends.", lastOutage.Seconds())
+	pw.header("downtime_availability_ratio", "gauge", "Fraction of the window the system was neither down nor suspended, time before the first recorded event counts as up.")
+	for i, window := range AvailabilityWindows {
+		pw.sample(fmt.Sprintf("downtime_availability_ratio{window=\"%dd\"}", window/day), availability[i])
+	}
+	if d != nil {
+		status := d.Status()
+		pw.metric("downtime_stamp_failures_total", "counter", "Failed updates of the time stamp.", float64(stampFailures))
+		if !status.Boot.IsZero() {
+			pw.metric("downtime_boot_time_seconds", "gauge", "Boot time in seconds since the epoch.", unixSeconds(status.Boot))
+		}
+		if !status.LastStamp.IsZero() {
+			pw.metric("downtime_last_stamp_age_seconds", "gauge", "Seconds since the time stamp was last updated.", d.clk.Since(status.LastStamp).Seconds())
+		}
+	}
+	return pw.n, pw.err
+}
+
+// WriteTextfile atomically replaces the file at path with the metrics, for the textfile collector of node_exporter
+// the file has to be in its collector directory and end in .prom.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = m.WriteTo(tmp)
+	if err == nil {
+		// node_exporter does not necessarily run as root
+		err = tmp.Chmod(0644)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := m.WriteTo(w)
+	if err != nil {
+		logger.Warningf("failed to write metrics: %s", err)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// promWriter writes metrics in the Prometheus text format and remembers the first error
+type promWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (p *promWriter) header(name, kind, help string) {
+	p.write(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind))
+}
+
+func (p *promWriter) sample(series string, value float64) {
+	p.write(series + " " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// metric writes a metric with a single unlabeled sample
+func (p *promWriter) metric(name, kind, help string, value float64) {
+	p.header(name, kind, help)
+	p.sample(name, value)
+}
+
+func (p *promWriter) write(s string) {
+	if p.err != nil {
+		return
+	}
+	n, err := io.WriteString(p.w, s)
+	p.n += int64(n)
+	p.err = err
+}
@@ -0,0 +1,91 @@
+package downtime
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	boot := time.Unix(1633484567, 0)
+	history := bytes.NewBuffer([]byte{})
+	w := NewDatabaseWriter(history)
+	require.NoError(t, w.Append(NewEvent(EventTypeCrash, boot.Add(-2*time.Hour))))
+	require.NoError(t, w.Append(NewEvent(EventTypeUp, boot.Add(-time.Hour-30*time.Minute))))
+	require.NoError(t, w.Append(NewEvent(EventTypeSuspend, boot.Add(-time.Hour-20*time.Minute))))
+	require.NoError(t, w.Append(NewEvent(EventTypeResume, boot.Add(-time.Hour-10*time.Minute))))
+	require.NoError(t, w.Append(NewEvent(EventTypeShutdown, boot.Add(-time.Hour))))
+
+	m := NewMetrics()
+	require.NoError(t, m.Load(NewDatabaseReader(bytes.NewReader(history.Bytes()))))
+
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	d := NewDaemonWithClock(store, NewFanOutWriter(Sink{Name: "metrics", Writer: m}), DefaultSleepSeconds*time.Second, clk)
+	defer m.Watch(d)()
+
+	store.boot = boot.Add(-3 * time.Hour)
+	store.shutdown = boot.Add(-time.Hour)
+	store.stamp = boot.Add(-time.Hour)
+	clk.Set(boot.Add(90 * time.Second))
+	require.NoError(t, d.Init(boot, time.Stamp))
+	d.stamp(false)
+	clk.Add(30 * time.Second)
+	store.setErr = fmt.Errorf("disk full")
+	d.stamp(false)
+
+	out := &strings.Builder{}
+	_, err := m.WriteTo(out)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP downtime_events_total Events recorded in the downtime database by type.
+# TYPE downtime_events_total counter
+downtime_events_total{type="Crash"} 1
+downtime_events_total{type="Resume"} 1
+downtime_events_total{type="Shutdown"} 2
+downtime_events_total{type="Suspend"} 1
+downtime_events_total{type="Up"} 2
+# HELP downtime_downtime_seconds_total Total duration of all complete outages, not counting suspends.
+# TYPE downtime_downtime_seconds_total counter
+downtime_downtime_seconds_total 5400
+# HELP downtime_suspended_seconds_total Total time the system was suspended.
+# TYPE downtime_suspended_seconds_total counter
+downtime_suspended_seconds_total 600
+# HELP downtime_last_outage_duration_seconds Duration of the most recent complete outage, not counting suspends.
+# TYPE downtime_last_outage_duration_seconds gauge
+downtime_last_outage_duration_seconds 3600
+# HELP downtime_availability_ratio Fraction of the window the system was neither down nor suspended, time before the first recorded event counts as up.
+# TYPE downtime_availability_ratio gauge
+downtime_availability_ratio{window="7d"} 0.9900793650793651
+downtime_availability_ratio{window="30d"} 0.9976851851851852
+downtime_availability_ratio{window="90d"} 0.9992283950617284
+# HELP downtime_stamp_failures_total Failed updates of the time stamp.
+# TYPE downtime_stamp_failures_total counter
+downtime_stamp_failures_total 1
+# HELP downtime_boot_time_seconds Boot time in seconds since the epoch.
+# TYPE downtime_boot_time_seconds gauge
+downtime_boot_time_seconds 1.633484567e+09
+# HELP downtime_last_stamp_age_seconds Seconds since the time stamp was last updated.
+# TYPE downtime_last_stamp_age_seconds gauge
+downtime_last_stamp_age_seconds 30
+`, out.String())
+
+	path := filepath.Join(t.TempDir(), "downtimed.prom")
+	require.NoError(t, m.WriteTextfile(path))
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, out.String(), string(written))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
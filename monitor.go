@@ -0,0 +1,54 @@
+package downtime
+
+import "time"
+
+// MonitorStopStore is implemented by data stores that can remember when the daemon stopped while the
+// system kept running, like DataDir.
+type MonitorStopStore interface {
+	SetMonitorStop(t time.Time) error
+	GetMonitorStop() (time.Time, error)
+}
+
+// ShutdownDetector tells whether the system is going down, the daemon asks it when it is stopped.
+type ShutdownDetector interface {
+	ShuttingDown() (bool, error)
+}
+
+// SystemShutdownDetector asks systemd whether the system is stopping, it fails on systems not run by systemd.
+func SystemShutdownDetector() ShutdownDetector {
+	return systemShutdownDetector()
+}
+
+// CoverageGaps returns the periods the daemon was not running while the system was up, each from a
+// MonitorStop event to the following MonitorStart or Up event. Either event has type None if it is missing
+// from the database. Events of different services are paired separately.
+func CoverageGaps(events []Event) []Outage {
+	return perService(events, coverageGaps)
+}
+
+func coverageGaps(events []Event) []Outage {
+	gaps := []Outage{}
+	var current *Outage
+	for _, event := range events {
+		switch event.What {
+		case EventTypeMonitorStop:
+			if current != nil {
+				gaps = append(gaps, *current)
+			}
+			current = &Outage{Down: event}
+		case EventTypeMonitorStart, EventTypeUp:
+			if current != nil {
+				current.Up = event
+				gaps = append(gaps, *current)
+				current = nil
+			} else if event.What == EventTypeMonitorStart {
+				// missing stop event
+				gaps = append(gaps, Outage{Up: event})
+			}
+		}
+	}
+	if current != nil {
+		gaps = append(gaps, *current)
+	}
+	return gaps
+}
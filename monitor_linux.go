@@ -0,0 +1,49 @@
+package downtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// systemdRuntimeDir exists if the system was booted with systemd
+const systemdRuntimeDir = "/run/systemd/system"
+
+// systemdTimeout bounds how long stopping the daemon waits for systemctl
+const systemdTimeout = 5 * time.Second
+
+// systemdShutdownDetector asks systemctl for the state of the system, which is "stopping" while it goes down
+type systemdShutdownDetector struct{}
+
+func systemShutdownDetector() ShutdownDetector {
+	return systemdShutdownDetector{}
+}
+
+func (systemdShutdownDetector) ShuttingDown() (bool, error) {
+	stdout, err := systemctl("is-system-running")
+	state := strings.TrimSpace(stdout)
+	// systemctl exits non-zero for every state but running, so only fail without an answer
+	if state == "" {
+		return false, err
+	}
+	return state == "stopping", nil
+}
+
+// systemctl runs systemctl with args and returns its output, along with its error if it failed
+func systemctl(args ...string) (string, error) {
+	_, err := os.Stat(systemdRuntimeDir)
+	if err != nil {
+		return "", errors.New("the system is not running systemd")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), systemdTimeout)
+	defer cancel()
+	stdout := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "systemctl", args...)
+	cmd.Stdout = stdout
+	err = cmd.Run()
+	return stdout.String(), err
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package downtime
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type unknownShutdownDetector struct{}
+
+func systemShutdownDetector() ShutdownDetector {
+	return unknownShutdownDetector{}
+}
+
+func (unknownShutdownDetector) ShuttingDown() (bool, error) {
+	return false, fmt.Errorf("os not supported: %s", runtime.GOOS)
+}
@@ -0,0 +1,39 @@
+package downtime
+
+import "time"
+
+/*
+MonotonicClock lets the daemon notice that the system was suspended, by comparing a clock that
+stops while the system is suspended with one that keeps running.
+*/
+type MonotonicClock interface {
+	// Monotonic is the time elapsed since an arbitrary point, not counting time spent suspended.
+	Monotonic() time.Duration
+	// Boottime is the time elapsed since an arbitrary point, including time spent suspended.
+	Boottime() time.Duration
+}
+
+// SystemMonotonicClock uses CLOCK_MONOTONIC and CLOCK_BOOTTIME where available, and compares
+// the monotonic clock of the Go runtime with the wall clock elsewhere.
+func SystemMonotonicClock() MonotonicClock {
+	return systemMonotonicClock()
+}
+
+// wallClock takes the wall clock for the boot time, so setting the clock looks like a suspend or
+// a negative one, but the Go runtime's monotonic clock stops during suspend on most systems
+type wallClock struct {
+	start time.Time
+}
+
+func newWallClock() wallClock {
+	return wallClock{start: time.Now()}
+}
+
+func (c wallClock) Monotonic() time.Duration {
+	return time.Since(c.start)
+}
+
+func (c wallClock) Boottime() time.Duration {
+	// Round(0) strips the monotonic reading
+	return time.Now().Round(0).Sub(c.start.Round(0))
+}
@@ -0,0 +1,37 @@
+package downtime
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// bootClock reads CLOCK_MONOTONIC, which stops while suspended, and CLOCK_BOOTTIME, which does not
+type bootClock struct{}
+
+func systemMonotonicClock() MonotonicClock {
+	var ts unix.Timespec
+	err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts)
+	if err != nil {
+		logger.Warningf("CLOCK_BOOTTIME not available, comparing with the wall clock: %s", err)
+		return newWallClock()
+	}
+	return bootClock{}
+}
+
+func clockGettime(id int32) time.Duration {
+	var ts unix.Timespec
+	err := unix.ClockGettime(id, &ts)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
+
+func (bootClock) Monotonic() time.Duration {
+	return clockGettime(unix.CLOCK_MONOTONIC)
+}
+
+func (bootClock) Boottime() time.Duration {
+	return clockGettime(unix.CLOCK_BOOTTIME)
+}
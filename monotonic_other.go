@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package downtime
+
+func systemMonotonicClock() MonotonicClock {
+	return newWallClock()
+}
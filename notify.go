@@ -0,0 +1,96 @@
+package downtime
+
+import (
+	"sync"
+	"time"
+)
+
+type NotificationKind int
+
+const (
+	// NotifyOutage is sent when Init detected an outage, Outage describes it.
+	NotifyOutage NotificationKind = iota + 1
+	// NotifyStampFailed is sent when the stamp could not be updated, Err says why.
+	NotifyStampFailed
+	// NotifyStamp is sent after the stamp was updated.
+	NotifyStamp
+)
+
+// Notification tells subscribers of a Daemon what it found out.
+type Notification struct {
+	Kind   NotificationKind
+	Time   time.Time
+	Outage *OutageReport
+	Err    error
+}
+
+// Subscribe calls fn with every notification until the returned function is called.
+// fn is called from the goroutine running Init or Run, so it should not block.
+func (d *Daemon) Subscribe(fn func(Notification)) (unsubscribe func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subscribers == nil {
+		d.subscribers = map[int]func(Notification){}
+	}
+	id := d.nextSubscriber
+	d.nextSubscriber++
+	d.subscribers[id] = fn
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.subscribers, id)
+	}
+}
+
+// Notifications delivers notifications on a channel with room for buffer of them,
+// notifications are dropped while the channel is full.
+// The channel is closed when the returned function is called.
+func (d *Daemon) Notifications(buffer int) (<-chan Notification, func()) {
+	ch := make(chan Notification, buffer)
+	var mu sync.Mutex
+	closed := false
+	unsubscribe := d.Subscribe(func(n Notification) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- n:
+		default:
+			logger.Warningf("notification channel full, dropping notification")
+		}
+	})
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+func (d *Daemon) notify(n Notification) {
+	d.mu.Lock()
+	if n.Kind == NotifyOutage {
+		d.lastOutage = n.Outage
+	}
+	subscribers := make([]func(Notification), 0, len(d.subscribers))
+	for _, fn := range d.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	d.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(n)
+	}
+}
+
+// LastOutage returns the outage detected by Init, or nil if there was none.
+func (d *Daemon) LastOutage() *OutageReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastOutage
+}
@@ -0,0 +1,60 @@
+package downtime
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemonNotifications(t *testing.T) {
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	boot := time.Unix(1633484567, 0)
+	d := NewDaemonWithClock(store, NewDatabaseWriter(bytes.NewBuffer([]byte{})), DefaultSleepSeconds*time.Second, clk)
+
+	received := []Notification{}
+	unsubscribe := d.Subscribe(func(n Notification) {
+		received = append(received, n)
+	})
+	ch, closeCh := d.Notifications(1)
+	assert.Nil(t, d.LastOutage())
+
+	store.boot = boot
+	clk.Set(boot.Add(time.Hour))
+	d.stamp(true)
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, NotifyStamp, received[0].Kind)
+		assert.Equal(t, boot.Add(time.Hour), received[0].Time)
+	}
+	assert.Equal(t, received[0], <-ch)
+	received = received[:0]
+
+	assert.NoError(t, d.Init(boot.Add(time.Hour+time.Minute), time.Stamp))
+
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, NotifyOutage, received[0].Kind)
+		assert.Equal(t, EventTypeShutdown, received[0].Outage.Down.What)
+		assert.Equal(t, time.Minute, received[0].Outage.Downtime)
+	}
+	assert.Equal(t, received[0].Outage, d.LastOutage())
+	assert.Equal(t, received[0], <-ch)
+
+	store.setErr = fmt.Errorf("disk full")
+	d.stamp(false)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, NotifyStampFailed, received[1].Kind)
+		assert.ErrorIs(t, received[1].Err, store.setErr)
+	}
+	assert.Equal(t, received[1], <-ch)
+
+	unsubscribe()
+	closeCh()
+	d.stamp(false)
+	assert.Len(t, received, 2)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,241 @@
+package downtime
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// Outage is a period the system was down, from a Shutdown or Crash event to the following Up event,
+// or suspended, from a Suspend event to the following Resume event.
+// CoverageGaps uses it for the periods the daemon was not running, from a MonitorStop event.
+// Either event has type None if it is missing from the database.
+type Outage struct {
+	Down Event
+	Up   Event
+}
+
+// Complete reports whether both ends of the outage are known.
+func (o Outage) Complete() bool {
+	return o.Down.What != EventTypeNone && o.Up.What != EventTypeNone
+}
+
+func (o Outage) Crashed() bool {
+	return o.Down.What == EventTypeCrash
+}
+
+// Suspended reports whether the system was suspended rather than down.
+func (o Outage) Suspended() bool {
+	return o.Down.What == EventTypeSuspend || o.Up.What == EventTypeResume
+}
+
+// Duration of the outage, zero if it is not complete.
+func (o Outage) Duration() time.Duration {
+	if !o.Complete() {
+		return 0
+	}
+	return o.Up.When.AsTime().Sub(o.Down.When.AsTime())
+}
+
+// Gap reports whether this is a gap in monitoring rather than an outage.
+func (o Outage) Gap() bool {
+	return o.Down.What == EventTypeMonitorStop || o.Up.What == EventTypeMonitorStart
+}
+
+// outageEnd returns the type of the event that ends an outage beginning with an event of type what,
+// None if events of type what do not begin an outage
+func outageEnd(what EventType) EventType {
+	switch what {
+	case EventTypeShutdown, EventTypeCrash:
+		return EventTypeUp
+	case EventTypeSuspend:
+		return EventTypeResume
+	}
+	return EventTypeNone
+}
+
+// outageStart reports whether event begins an outage or a gap in monitoring
+func outageStart(event Event) bool {
+	return outageEnd(event.What) != EventTypeNone || event.What == EventTypeMonitorStop
+}
+
+func isOutageEnd(what EventType) bool {
+	return what == EventTypeUp || what == EventTypeResume
+}
+
+// Outages pairs up events into outages, a down event without an up event or vice-versa
+// results in an incomplete outage. Events of different services are paired separately.
+func Outages(events []Event) []Outage {
+	return perService(events, pairOutages)
+}
+
+func pairOutages(events []Event) []Outage {
+	outages := []Outage{}
+	var current *Outage
+	for _, event := range events {
+		switch {
+		case outageEnd(event.What) != EventTypeNone:
+			if current != nil {
+				// missing up event
+				outages = append(outages, *current)
+			}
+			current = &Outage{Down: event}
+		case isOutageEnd(event.What):
+			if current != nil && outageEnd(current.Down.What) != event.What {
+				// missing up event, and down event of this one
+				outages = append(outages, *current)
+				current = nil
+			}
+			if current == nil {
+				// missing down event
+				outages = append(outages, Outage{Up: event})
+				continue
+			}
+			current.Up = event
+			outages = append(outages, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		outages = append(outages, *current)
+	}
+	return outages
+}
+
+// LastOutages returns the last n complete outages of the system and any service in chronological order,
+// walking the database from the end.
+func LastOutages(r EventReader, n int) ([]Outage, error) {
+	return lastOutages(r, n, func(Event) bool { return true })
+}
+
+// LastServiceOutages is like LastOutages, but only returns outages of the named service, or of the system if it is empty.
+func LastServiceOutages(r EventReader, service string, n int) ([]Outage, error) {
+	return lastOutages(r, n, func(event Event) bool { return event.Service == service })
+}
+
+/*
+LastServiceEvents returns the events of the named service, or of the system if it is empty, from the n-th
+last event beginning an outage or a gap in monitoring to the end, walking the database from the end.
+Outages and CoverageGaps of them return the last n outages and gaps as if all events were read.
+*/
+func LastServiceEvents(r EventReader, service string, n int) ([]Event, error) {
+	events := []Event{}
+	err := r.End()
+	if err != nil {
+		return events, err
+	}
+	for starts := 0; starts < n; {
+		event, err := r.Prev()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return events, err
+		}
+		if event.Service != service {
+			continue
+		}
+		events = append(events, event)
+		if outageStart(event) {
+			starts++
+		}
+	}
+	reverseEvents(events)
+	return events, nil
+}
+
+func lastOutages(r EventReader, n int, keep func(Event) bool) ([]Outage, error) {
+	outages := []Outage{}
+	err := r.End()
+	if err != nil {
+		return outages, err
+	}
+	// the up event following the events read so far, by service
+	ups := map[string]Event{}
+	for len(outages) < n {
+		event, err := r.Prev()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return outages, err
+		}
+		if !keep(event) {
+			continue
+		}
+		up, haveUp := ups[event.Service]
+		switch {
+		case isOutageEnd(event.What):
+			ups[event.Service] = event
+		case haveUp && outageEnd(event.What) == up.What:
+			outages = append(outages, Outage{Down: event, Up: up})
+			delete(ups, event.Service)
+		case outageEnd(event.What) != EventTypeNone:
+			// down event without an up event
+			delete(ups, event.Service)
+		}
+	}
+	for i, j := 0, len(outages)-1; i < j; i, j = i+1, j-1 {
+		outages[i], outages[j] = outages[j], outages[i]
+	}
+	return outages, nil
+}
+
+// perService pairs the events of each service separately and merges the results in order of their start
+func perService(events []Event, pair func([]Event) []Outage) []Outage {
+	services := []string{}
+	byService := map[string][]Event{}
+	for _, event := range events {
+		if _, ok := byService[event.Service]; !ok {
+			services = append(services, event.Service)
+		}
+		byService[event.Service] = append(byService[event.Service], event)
+	}
+	if len(services) <= 1 {
+		return pair(events)
+	}
+	merged := []Outage{}
+	for _, service := range services {
+		merged = append(merged, pair(byService[service])...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].start().When < merged[j].start().When
+	})
+	return merged
+}
+
+// start returns the first known event of the outage
+func (o Outage) start() Event {
+	if o.Down.What == EventTypeNone {
+		return o.Up
+	}
+	return o.Down
+}
+
+// Availability is the fraction of [from, to) not covered by the complete outages, time before
+// the first recorded event counts as available. Leave out suspended outages to count the time
+// the system was suspended as available.
+func Availability(outages []Outage, from, to time.Time) float64 {
+	window := to.Sub(from)
+	if window <= 0 {
+		return 1
+	}
+	var down time.Duration
+	for _, outage := range outages {
+		if !outage.Complete() {
+			continue
+		}
+		start, end := outage.Down.When.AsTime(), outage.Up.When.AsTime()
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			down += end.Sub(start)
+		}
+	}
+	return 1 - float64(down)/float64(window)
+}
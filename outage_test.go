@@ -0,0 +1,187 @@
+package downtime_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastOutages(t *testing.T) {
+	db, err := downtime.OpenDatabaseReader("./test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	events, err := db.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := downtime.Outages(events)
+	assert.Len(t, all, len(events)/2)
+
+	last, err := downtime.LastOutages(db, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, all[len(all)-3:], last)
+
+	lastEvents, err := db.Last(4)
+	assert.NoError(t, err)
+	assert.Equal(t, events[len(events)-4:], lastEvents)
+}
+
+func TestLastOutagesMissingEvents(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	events := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeShutdown, at(10)),
+		downtime.NewEvent(downtime.EventTypeUp, at(20)),
+		downtime.NewEvent(downtime.EventTypeCrash, at(30)),
+		// missing up
+		downtime.NewEvent(downtime.EventTypeShutdown, at(40)),
+		downtime.NewEvent(downtime.EventTypeUp, at(50)),
+		// missing down
+		downtime.NewEvent(downtime.EventTypeUp, at(60)),
+	}
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	for _, event := range events {
+		assert.NoError(t, w.Append(event))
+	}
+
+	r := downtime.NewDatabaseReader(bytes.NewReader(buff.Bytes()))
+	last, err := downtime.LastOutages(r, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Outage{
+		{Down: events[0], Up: events[1]},
+		{Down: events[3], Up: events[4]},
+	}, last)
+
+	all := downtime.Outages(events)
+	assert.Len(t, all, 4)
+	assert.False(t, all[1].Complete())
+	assert.True(t, all[1].Crashed())
+	assert.False(t, all[3].Complete())
+	assert.Equal(t, 10*time.Second, all[2].Duration())
+}
+
+func TestAvailability(t *testing.T) {
+	start := time.Unix(1633484567, 0)
+	outages := []downtime.Outage{
+		{Down: downtime.NewEvent(downtime.EventTypeCrash, start.Add(-time.Hour)), Up: downtime.NewEvent(downtime.EventTypeUp, start.Add(time.Hour))},
+		{Down: downtime.NewEvent(downtime.EventTypeShutdown, start.Add(5*time.Hour)), Up: downtime.NewEvent(downtime.EventTypeUp, start.Add(6*time.Hour))},
+		{Up: downtime.NewEvent(downtime.EventTypeUp, start.Add(7*time.Hour))},
+	}
+	assert.Equal(t, 0.8, downtime.Availability(outages, start, start.Add(10*time.Hour)), "outages are clipped to the window")
+	assert.Equal(t, 1.0, downtime.Availability(outages, start.Add(2*time.Hour), start.Add(4*time.Hour)))
+	assert.Equal(t, 0.0, downtime.Availability(outages, start.Add(5*time.Hour), start.Add(6*time.Hour)))
+	assert.Equal(t, 1.0, downtime.Availability(nil, start, start))
+}
+
+func TestOutagesWithSuspends(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	events := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeCrash, at(10)),
+		downtime.NewEvent(downtime.EventTypeUp, at(20)),
+		downtime.NewEvent(downtime.EventTypeSuspend, at(30)),
+		downtime.NewEvent(downtime.EventTypeResume, at(45)),
+		downtime.NewEvent(downtime.EventTypeSuspend, at(50)),
+		// missing resume
+		downtime.NewEvent(downtime.EventTypeUp, at(60)),
+	}
+	all := downtime.Outages(events)
+	if assert.Len(t, all, 4) {
+		assert.Equal(t, downtime.Outage{Down: events[2], Up: events[3]}, all[1])
+		assert.True(t, all[1].Suspended())
+		assert.False(t, all[1].Crashed())
+		assert.Equal(t, 15*time.Second, all[1].Duration())
+		assert.False(t, all[2].Complete(), "a suspend does not end with an up event")
+		assert.False(t, all[3].Complete())
+	}
+
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	for _, event := range events {
+		assert.NoError(t, w.Append(event))
+	}
+	last, err := downtime.LastOutages(downtime.NewDatabaseReader(bytes.NewReader(buff.Bytes())), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []downtime.Outage{all[0], all[1]}, last)
+}
+
+func TestCoverageGaps(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	events := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeMonitorStop, at(10)),
+		downtime.NewEvent(downtime.EventTypeMonitorStart, at(20)),
+		downtime.NewEvent(downtime.EventTypeMonitorStop, at(30)),
+		// rebooted while not monitored
+		downtime.NewEvent(downtime.EventTypeUp, at(50)),
+		downtime.NewEvent(downtime.EventTypeCrash, at(60)),
+		downtime.NewEvent(downtime.EventTypeUp, at(70)),
+		// missing stop
+		downtime.NewEvent(downtime.EventTypeMonitorStart, at(80)),
+	}
+	gaps := downtime.CoverageGaps(events)
+	assert.Equal(t, []downtime.Outage{
+		{Down: events[0], Up: events[1]},
+		{Down: events[2], Up: events[3]},
+		{Up: events[6]},
+	}, gaps)
+	assert.True(t, gaps[1].Gap())
+	assert.Equal(t, 20*time.Second, gaps[1].Duration())
+
+	all := downtime.Outages(events)
+	assert.Len(t, all, 2, "gaps are no outages")
+	assert.False(t, all[0].Complete())
+}
+
+// countingReader counts the bytes read from the database
+type countingReader struct {
+	*bytes.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestLastServiceEvents(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(1633484567+sec, 0) }
+	events := []downtime.Event{}
+	for i := int64(0); i < 1000; i += 10 {
+		events = append(events,
+			downtime.NewEvent(downtime.EventTypeShutdown, at(i)),
+			downtime.NewEvent(downtime.EventTypeUp, at(i+2)),
+			downtime.NewEvent(downtime.EventTypeMonitorStop, at(i+4)),
+			downtime.NewEvent(downtime.EventTypeMonitorStart, at(i+6)))
+		crash := downtime.NewEvent(downtime.EventTypeCrash, at(i+7))
+		crash.Service = "api"
+		events = append(events, crash)
+	}
+	buff := bytes.NewBuffer([]byte{})
+	w := downtime.NewDatabaseWriter(buff)
+	for _, event := range events {
+		assert.NoError(t, w.Append(event))
+	}
+
+	r := &countingReader{Reader: bytes.NewReader(buff.Bytes())}
+	last, err := downtime.LastServiceEvents(downtime.NewDatabaseReader(r), "", 3)
+	assert.NoError(t, err)
+	assert.Less(t, r.read, buff.Len()/10, "only the end is read")
+
+	system := []downtime.Event{}
+	for _, event := range events {
+		if event.Service == "" {
+			system = append(system, event)
+		}
+	}
+	all := downtime.Outages(system)
+	gaps := downtime.CoverageGaps(system)
+	// the last three are a gap, an outage and a gap
+	assert.Equal(t, all[len(all)-1:], downtime.Outages(last))
+	assert.Equal(t, gaps[len(gaps)-2:], downtime.CoverageGaps(last))
+}
@@ -0,0 +1,24 @@
+package downtime
+
+import (
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+func processStartTime(pid int) (time.Time, error) {
+	proc, err := procfs.NewProc(pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	start, err := stat.StartTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the start time is counted in clock ticks, rounding drops the noise of the conversion to float
+	return time.Unix(0, int64(start*float64(time.Second))).Round(time.Millisecond), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package downtime
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func processStartTime(pid int) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("os not supported: %s", runtime.GOOS)
+}
@@ -0,0 +1,195 @@
+package downtime
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultPstoreDir is where the kernel mounts pstore, the records of a crash it keeps across the reboot.
+const DefaultPstoreDir = "/sys/fs/pstore"
+
+// headlineFile keeps the headline of a crash in its archive directory
+const headlineFile = "headline"
+
+// CrashArchive keeps the pstore records of crashes in the crashes directory of a data directory,
+// one directory per crash named after the time of the Crash event.
+type CrashArchive struct {
+	dir string
+}
+
+func NewCrashArchive(dataDir string) CrashArchive {
+	return CrashArchive{dir: filepath.Join(dataDir, "crashes")}
+}
+
+// Dir returns the directory the records of the crash are archived in.
+func (a CrashArchive) Dir(crash Event) string {
+	return filepath.Join(a.dir, crash.When.AsTime().UTC().Format("20060102T150405.000000000Z"))
+}
+
+/*
+Collect archives the records in pstoreDir written after the crash, the kernel dates each record with
+the time it was written. It returns the headline of the crash, the line of the kernel log that tells
+what went wrong, which is empty if there are no records or none tells.
+*/
+func (a CrashArchive) Collect(pstoreDir string, crash Event) (string, error) {
+	entries, err := os.ReadDir(pstoreDir)
+	if err != nil {
+		return "", err
+	}
+	crashed := crash.When.AsTime()
+	var names []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		if info.Mode().IsRegular() && info.ModTime().After(crashed) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+
+	dir := a.Dir(crash)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		err = copyRecord(filepath.Join(pstoreDir, name), filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+	}
+	headline, err := findHeadline(dir, names)
+	if err != nil || headline == "" {
+		return "", err
+	}
+	return headline, os.WriteFile(filepath.Join(dir, headlineFile), []byte(headline+"\n"), 0600)
+}
+
+// Headline returns the headline found by Collect, it fails with os.ErrNotExist if there is none.
+func (a CrashArchive) Headline(crash Event) (string, error) {
+	b, err := os.ReadFile(filepath.Join(a.Dir(crash), headlineFile))
+	return strings.TrimSpace(string(b)), err
+}
+
+// PstoreHook returns a hook collecting the records in pstoreDir after a crash of the system.
+func (a CrashArchive) PstoreHook(pstoreDir string) Hook {
+	return pstoreHook{archive: a, pstore: pstoreDir}
+}
+
+type pstoreHook struct {
+	archive CrashArchive
+	pstore  string
+}
+
+func (h pstoreHook) Run(report OutageReport) error {
+	if !report.Crashed() || report.Down.Service != "" {
+		return nil
+	}
+	headline, err := h.archive.Collect(h.pstore, report.Down)
+	if errors.Is(err, os.ErrNotExist) {
+		// pstore is not mounted
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("collecting %s: %w", h.pstore, err)
+	}
+	if headline != "" {
+		logger.Infof("crash: %s", headline)
+	}
+	return nil
+}
+
+// copyRecord copies a pstore record keeping its time, which dates the crash
+func copyRecord(from, to string) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// headlinePrefixes start the kernel log lines telling what went wrong, the reason of an oops is more
+// telling than the panic it ends in, which is only used if there is nothing else
+var (
+	headlinePrefixes = []string{"BUG: ", "Oops: ", "Unable to handle kernel ", "general protection fault", "kernel BUG at "}
+	panicPrefix      = "Kernel panic - not syncing: "
+)
+
+// findHeadline looks for the headline in the kernel logs among the records, dmesg-* in pstore
+func findHeadline(dir string, names []string) (string, error) {
+	var panicLine string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "dmesg-") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := stripLogPrefix(scanner.Text())
+			for _, prefix := range headlinePrefixes {
+				if strings.HasPrefix(line, prefix) {
+					f.Close()
+					return line, nil
+				}
+			}
+			if panicLine == "" && strings.HasPrefix(line, panicPrefix) {
+				panicLine = line
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return panicLine, nil
+}
+
+// stripLogPrefix removes the log level and time stamp of a kernel log line, e.g. "<1>[   12.345678] "
+func stripLogPrefix(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "<") {
+		if i := strings.Index(line, ">"); i != -1 {
+			line = line[i+1:]
+		}
+	}
+	if strings.HasPrefix(line, "[") {
+		if i := strings.Index(line, "]"); i != -1 {
+			line = line[i+1:]
+		}
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,74 @@
+package downtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePstoreRecord(t *testing.T, dir, name, content string, written time.Time) {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0400))
+	require.NoError(t, os.Chtimes(path, written, written))
+}
+
+func TestCrashArchive(t *testing.T) {
+	pstore := t.TempDir()
+	dataDir := t.TempDir()
+	crash := downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633484567, 123456789))
+	crashed := crash.When.AsTime()
+
+	writePstoreRecord(t, pstore, "dmesg-ramoops-0", "Panic#1 Part1\n"+
+		"<6>[   10.000000] eth0: link up\n"+
+		"<1>[   12.345678] BUG: kernel NULL pointer dereference, address: 0000000000000000\n"+
+		"<0>[   12.400000] Kernel panic - not syncing: Fatal exception\n", crashed.Add(5*time.Second))
+	writePstoreRecord(t, pstore, "console-ramoops-0", "[   12.345678] BUG: on the console\n", crashed.Add(5*time.Second))
+	writePstoreRecord(t, pstore, "dmesg-ramoops-1", "<0>[    1.000000] Kernel panic - not syncing: an older crash\n", crashed.Add(-time.Hour))
+
+	archive := downtime.NewCrashArchive(dataDir)
+	headline, err := archive.Collect(pstore, crash)
+	require.NoError(t, err)
+	assert.Equal(t, "BUG: kernel NULL pointer dereference, address: 0000000000000000", headline)
+
+	archived, err := os.ReadDir(archive.Dir(crash))
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range archived {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"console-ramoops-0", "dmesg-ramoops-0", "headline"}, names, "records before the crash are left out")
+	info, err := os.Stat(filepath.Join(archive.Dir(crash), "dmesg-ramoops-0"))
+	require.NoError(t, err)
+	assert.Equal(t, crashed.Add(5*time.Second).Unix(), info.ModTime().Unix())
+
+	stored, err := archive.Headline(crash)
+	require.NoError(t, err)
+	assert.Equal(t, headline, stored)
+
+	// a later crash that only left the panic
+	later := downtime.NewEvent(downtime.EventTypeCrash, crashed.Add(time.Hour))
+	writePstoreRecord(t, pstore, "dmesg-efi-1", "<0>[    3.000000] Kernel panic - not syncing: VFS: Unable to mount root fs\n", crashed.Add(2*time.Hour))
+	hook := archive.PstoreHook(pstore)
+	require.NoError(t, hook.Run(downtime.OutageReport{Outage: downtime.Outage{Down: later}}))
+	stored, err = archive.Headline(later)
+	require.NoError(t, err)
+	assert.Equal(t, "Kernel panic - not syncing: VFS: Unable to mount root fs", stored)
+
+	// nothing new
+	latest := downtime.NewEvent(downtime.EventTypeCrash, crashed.Add(3*time.Hour))
+	headline, err = archive.Collect(pstore, latest)
+	require.NoError(t, err)
+	assert.Empty(t, headline)
+	_, err = archive.Headline(latest)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	shutdown := downtime.NewEvent(downtime.EventTypeShutdown, crashed.Add(-time.Minute))
+	require.NoError(t, hook.Run(downtime.OutageReport{Outage: downtime.Outage{Down: shutdown}}))
+	_, err = archive.Headline(shutdown)
+	assert.ErrorIs(t, err, os.ErrNotExist, "only crashes are collected")
+}
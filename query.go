@@ -0,0 +1,261 @@
+package downtime
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// Query selects events from a database, the zero value selects everything.
+type Query struct {
+	// Since excludes events before this time, unless it is zero.
+	Since time.Time
+	// Until excludes events at or after this time, unless it is zero.
+	Until time.Time
+	// Types limits the result to these event types, unless it is empty.
+	Types []EventType
+	// Services limits the result to events of these services, "" being the system, unless it is empty.
+	Services []string
+	// Limit is the maximum number of events returned, unless it is zero.
+	Limit int
+	// Descending returns the newest events first.
+	Descending bool
+}
+
+func (q Query) inRange(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+func (q Query) matches(event Event) bool {
+	if !q.inRange(event.When.AsTime()) {
+		return false
+	}
+	if len(q.Services) > 0 && !containsService(q.Services, event.Service) {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, what := range q.Types {
+		if event.What == what {
+			return true
+		}
+	}
+	return false
+}
+
+func containsService(services []string, service string) bool {
+	for _, s := range services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Query) full(events []Event) bool {
+	return q.Limit > 0 && len(events) >= q.Limit
+}
+
+// Between returns the events from start up to, but not including, end.
+func (db *DatabaseReader) Between(start, end time.Time) ([]Event, error) {
+	return db.Query(Query{Since: start, Until: end})
+}
+
+// Query returns the events selected by q. Records are expected to be in time order, which allows
+// the start of the range to be found with a binary search; if the search or the walk through the
+// range runs into records out of order the whole database is scanned instead.
+func (db *DatabaseReader) Query(q Query) ([]Event, error) {
+	if q.Since.IsZero() && q.Until.IsZero() {
+		return db.scan(q)
+	}
+
+	count, err := db.Count()
+	if err != nil {
+		return nil, err
+	}
+	search := &timeSearch{db: db}
+	start, end := int64(0), count
+	if !q.Since.IsZero() {
+		start = search.first(count, q.Since)
+	}
+	if !q.Until.IsZero() {
+		end = search.first(count, q.Until)
+	}
+	if search.err != nil {
+		return nil, search.err
+	}
+	if !search.ordered() {
+		logger.Debugf("records out of order, scanning the whole database")
+		return db.scan(q)
+	}
+
+	var events []Event
+	if q.Descending {
+		events, err = db.walkBack(q, end)
+	} else {
+		events, err = db.walkForward(q, start)
+	}
+	if errors.Is(err, errOutOfOrder) {
+		logger.Debugf("records out of order, scanning the whole database")
+		return db.scan(q)
+	}
+	return events, err
+}
+
+var errOutOfOrder = errors.New("records out of order")
+
+// scan reads every record in the database
+func (db *DatabaseReader) scan(q Query) ([]Event, error) {
+	events := []Event{}
+	err := db.Reset()
+	if err != nil {
+		return events, err
+	}
+	for {
+		e, err := db.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return events, err
+		}
+		if q.matches(e) {
+			events = append(events, e)
+		}
+	}
+	if q.Descending {
+		reverseEvents(events)
+	}
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+	return events, nil
+}
+
+func (db *DatabaseReader) walkForward(q Query, start int64) ([]Event, error) {
+	events := []Event{}
+	err := db.SeekRecord(start)
+	if err != nil {
+		return events, err
+	}
+	var prev time.Time
+	for !q.full(events) {
+		e, err := db.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return events, err
+		}
+		when := e.When.AsTime()
+		if when.Before(prev) {
+			return events, errOutOfOrder
+		}
+		prev = when
+		if !q.Until.IsZero() && !when.Before(q.Until) {
+			break
+		}
+		if q.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (db *DatabaseReader) walkBack(q Query, end int64) ([]Event, error) {
+	events := []Event{}
+	err := db.SeekRecord(end)
+	if err != nil {
+		return events, err
+	}
+	var prev time.Time
+	for !q.full(events) {
+		e, err := db.Prev()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return events, err
+		}
+		when := e.When.AsTime()
+		if !prev.IsZero() && when.After(prev) {
+			return events, errOutOfOrder
+		}
+		prev = when
+		if !q.Since.IsZero() && when.Before(q.Since) {
+			break
+		}
+		if q.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// timeSearch binary searches the database by time, remembering every record it probes
+// so the caller can check they were in order.
+type timeSearch struct {
+	db     *DatabaseReader
+	probes map[int64]time.Time
+	err    error
+}
+
+// first returns the index of the first record at or after t, or count if there is none
+func (s *timeSearch) first(count int64, t time.Time) int64 {
+	return int64(sort.Search(int(count), func(i int) bool {
+		when, ok := s.probe(int64(i))
+		return !ok || !when.Before(t)
+	}))
+}
+
+// probe returns the time of the record at index, false if there is none or it can not be read
+func (s *timeSearch) probe(index int64) (time.Time, bool) {
+	if s.err != nil {
+		return time.Time{}, false
+	}
+	if s.probes == nil {
+		s.probes = map[int64]time.Time{}
+	}
+	if when, ok := s.probes[index]; ok {
+		return when, true
+	}
+	err := s.db.SeekRecord(index)
+	if err == nil {
+		var e Event
+		e, err = s.db.Next()
+		s.probes[index] = e.When.AsTime()
+	}
+	if errors.Is(err, io.EOF) {
+		// only skipped corrupt records are left, they count as past the end
+		delete(s.probes, index)
+		return time.Time{}, false
+	}
+	if err != nil {
+		s.err = err
+		return time.Time{}, false
+	}
+	return s.probes[index], true
+}
+
+// ordered reports whether the probed records were in time order
+func (s *timeSearch) ordered() bool {
+	indexes := make([]int64, 0, len(s.probes))
+	for index := range s.probes {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for i := 1; i < len(indexes); i++ {
+		if s.probes[indexes[i]].Before(s.probes[indexes[i-1]]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package downtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/juju/loggo"
+)
+
+// MaxServiceName is the length of the longest service name that fits in a database record.
+const MaxServiceName = 32
+
+// ValidateServiceName checks that name can be used for a tracked service, it names a directory
+// of the DataDir and is stored with every event.
+func ValidateServiceName(name string) error {
+	if name == "" || len(name) > MaxServiceName {
+		return fmt.Errorf("service name %q must be 1 to %d bytes long", name, MaxServiceName)
+	}
+	if name[0] == '.' {
+		return fmt.Errorf("service name %q must not start with a dot", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("service name %q may only contain letters, digits, '-', '_' and '.'", name)
+		}
+	}
+	return nil
+}
+
+/*
+MultiTracker tracks the downtime of several named services. Each service has a Daemon of its own,
+which keeps its state in the namespace of the service in a ServiceDataStore, like a DataDir, and tags
+the events it records with the name of the service, so they can share one database.
+Suspends and steps of the clock are detected once for all services, they happen to the whole system
+and are recorded by the Daemon of the system. The tracker only moves the start times of the services
+along with the clock.
+*/
+type MultiTracker struct {
+	store    ServiceDataStore
+	database EventWriter
+	sleep    time.Duration
+	clk      clock.Clock
+	watch    clockWatch
+	services []*trackedService
+}
+
+type trackedService struct {
+	name     string
+	bootTime func() (time.Time, error)
+	daemon   *Daemon
+	running  bool
+	boot     time.Time
+}
+
+func NewMultiTracker(store ServiceDataStore, database EventWriter, sleep time.Duration) *MultiTracker {
+	return NewMultiTrackerWithClock(store, database, sleep, clock.New())
+}
+
+func NewMultiTrackerWithClock(store ServiceDataStore, database EventWriter, sleep time.Duration, clk clock.Clock) *MultiTracker {
+	return &MultiTracker{
+		store:    store,
+		database: database,
+		sleep:    sleep,
+		clk:      clk,
+		watch: clockWatch{
+			clk:  clk,
+			mono: SystemMonotonicClock(),
+		},
+	}
+}
+
+// SetMonotonicClock replaces the clocks used to detect steps of the clock, by default SystemMonotonicClock.
+func (m *MultiTracker) SetMonotonicClock(mono MonotonicClock) {
+	m.watch.mono = mono
+}
+
+/*
+Track adds a service. Before every stamp bootTime is asked when the service started, an error means
+it is not running. A service that stopped is not stamped until it runs again, at which point the
+outage is recorded, as is one where it started again in between. The returned daemon can be used to
+subscribe to the service or add hooks, it is run by the tracker.
+*/
+func (m *MultiTracker) Track(name string, bootTime func() (time.Time, error)) (*Daemon, error) {
+	err := ValidateServiceName(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range m.services {
+		if s.name == name {
+			return nil, fmt.Errorf("service %q is already tracked", name)
+		}
+	}
+	store, err := m.store.ServiceStore(name)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDaemonWithClock(store, m.database, m.sleep, m.clk)
+	d.service = name
+	d.logger = loggo.GetLogger("downtime.service." + name)
+	m.services = append(m.services, &trackedService{name: name, bootTime: bootTime, daemon: d})
+	return d, nil
+}
+
+// Run checks on every service after each sleep until ctx is done.
+func (m *MultiTracker) Run(ctx context.Context, timeFormat string) error {
+	m.check(timeFormat)
+	for {
+		select {
+		case <-m.clk.After(m.sleep):
+			m.check(timeFormat)
+		case <-ctx.Done():
+			for _, s := range m.services {
+				if s.running {
+					s.daemon.stop()
+				}
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *MultiTracker) check(timeFormat string) {
+	var jump time.Duration
+	if m.watch.lastTick.IsZero() {
+		m.watch.mark()
+	} else {
+		_, _, jump = m.watch.elapse()
+	}
+	for _, s := range m.services {
+		s.check(timeFormat, jump)
+	}
+}
+
+// check stamps a running service, and initializes its daemon whenever it started.
+// jump is how far the clock was stepped since the last check.
+func (s *trackedService) check(timeFormat string, jump time.Duration) {
+	boot, err := s.bootTime()
+	if err != nil {
+		if s.running {
+			logger.Infof("service %s stopped: %s", s.name, err)
+			s.running = false
+		}
+		return
+	}
+	if s.running {
+		// the start time is derived from the boot time on the wall clock, so it moves when the clock is stepped
+		if jump != 0 {
+			s.boot = s.boot.Add(jump)
+			s.daemon.shiftBoot(jump)
+		}
+		if absDuration(boot.Sub(s.boot)) < sameBootTolerance {
+			s.daemon.stamp(false)
+			return
+		}
+	}
+	err = s.daemon.Init(boot, timeFormat)
+	if err != nil {
+		logger.Errorf("service %s: %s", s.name, err)
+		return
+	}
+	s.running = true
+	s.boot = boot
+	s.daemon.stamp(false)
+}
@@ -0,0 +1,110 @@
+package downtime
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateServiceName(t *testing.T) {
+	for _, name := range []string{"api", "worker-2", "my_app.v1"} {
+		assert.NoError(t, ValidateServiceName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "with space", "0123456789012345678901234567890123"} {
+		assert.Error(t, ValidateServiceName(name), name)
+	}
+}
+
+func TestMultiTracker(t *testing.T) {
+	dir := t.TempDir()
+	dd, err := NewDataDir(dir)
+	require.NoError(t, err)
+	buff := bytes.NewBuffer([]byte{})
+	clk := clock.NewMock()
+	start := time.Unix(1633484567, 0)
+	clk.Set(start)
+	mono := &mockMonotonicClock{}
+	sleep := DefaultSleepSeconds * time.Second
+
+	tracker := NewMultiTrackerWithClock(dd, NewDatabaseWriter(buff), sleep, clk)
+	tracker.SetMonotonicClock(mono)
+	apiBoot := start
+	var apiErr error
+	api, err := tracker.Track("api", func() (time.Time, error) { return apiBoot, apiErr })
+	require.NoError(t, err)
+	worker, err := tracker.Track("worker", func() (time.Time, error) { return time.Time{}, fmt.Errorf("not running") })
+	require.NoError(t, err)
+	_, err = tracker.Track("api", nil)
+	assert.Error(t, err, "names are unique")
+	_, err = tracker.Track("../api", nil)
+	assert.Error(t, err)
+
+	step := func() {
+		clk.Add(sleep)
+		mono.Add(sleep, 0)
+		tracker.check(time.Stamp)
+	}
+	tracker.check(time.Stamp)
+	step()
+	lastSeen := clk.Now()
+	assert.Equal(t, lastSeen, api.Status().LastStamp)
+	assert.True(t, worker.Status().LastStamp.IsZero(), "a service that is not running is not stamped")
+
+	// the api stops and is started again
+	apiErr = fmt.Errorf("not running")
+	step()
+	step()
+	assert.Equal(t, lastSeen, api.Status().LastStamp)
+	apiErr = nil
+	apiBoot = clk.Now().Add(10 * time.Second)
+	step()
+	restarted := apiBoot
+
+	// the clock is stepped forward, which moves the start time of the service too
+	clk.Add(sleep + time.Hour)
+	mono.Add(sleep, 0)
+	apiBoot = apiBoot.Add(time.Hour)
+	tracker.check(time.Stamp)
+	step()
+	// and back
+	clk.Add(sleep - 2*time.Hour)
+	mono.Add(sleep, 0)
+	apiBoot = apiBoot.Add(-2 * time.Hour)
+	tracker.check(time.Stamp)
+	step()
+	// a suspend is no outage of the services either
+	clk.Add(sleep + time.Hour)
+	mono.Add(sleep, time.Hour)
+	tracker.check(time.Stamp)
+
+	events, err := NewDatabaseReader(bytes.NewReader(buff.Bytes())).All()
+	require.NoError(t, err)
+	crash := NewEvent(EventTypeCrash, lastSeen)
+	crash.Service = "api"
+	up := NewEvent(EventTypeUp, restarted)
+	up.Service = "api"
+	assert.Equal(t, []Event{crash, up}, events, "a step of the clock is no restart, it and suspends are recorded by the system")
+	apiStore, err := dd.Service("api")
+	require.NoError(t, err)
+	boot, err := apiStore.GetBoot()
+	require.NoError(t, err)
+	assert.Equal(t, apiBoot, boot, "the start time moved with the clock")
+
+	stamp, err := os.Stat(filepath.Join(dir, "services", "api", "downtimed.stamp"))
+	require.NoError(t, err)
+	assert.Equal(t, clk.Now(), stamp.ModTime())
+	_, err = dd.GetStamp()
+	assert.Error(t, err, "the state of the system is separate")
+
+	// events of the system and services are paired separately
+	system := []Event{NewEvent(EventTypeShutdown, lastSeen.Add(time.Second)), NewEvent(EventTypeUp, apiBoot.Add(time.Second))}
+	all := Outages([]Event{crash, system[0], up, system[1]})
+	assert.Equal(t, []Outage{{Down: crash, Up: up}, {Down: system[0], Up: system[1]}}, all)
+}
@@ -0,0 +1,88 @@
+//go:generate go-enum -f=$GOFILE --marshal
+
+package downtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+ShutdownKind tells why the system was shut down, it is stored with Shutdown events.
+
+ENUM(
+unknown = 0
+reboot = 1
+poweroff = 2
+halt = 3
+power-failure = 4
+)
+*/
+type ShutdownKind uint8
+
+// ShutdownKindSource tells what kind of shutdown the system is going through, the daemon asks it when
+// it records a shutdown.
+type ShutdownKindSource interface {
+	ShutdownKind() (ShutdownKind, error)
+}
+
+// ShutdownKindFunc is a ShutdownKindSource calling a function.
+type ShutdownKindFunc func() (ShutdownKind, error)
+
+func (f ShutdownKindFunc) ShutdownKind() (ShutdownKind, error) {
+	return f()
+}
+
+// ShutdownKindSources asks each source in turn and returns the first kind that is known.
+type ShutdownKindSources []ShutdownKindSource
+
+func (s ShutdownKindSources) ShutdownKind() (ShutdownKind, error) {
+	var errs []string
+	for _, source := range s {
+		kind, err := source.ShutdownKind()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if kind != ShutdownKindUnknown {
+			return kind, nil
+		}
+	}
+	if len(errs) > 0 {
+		return ShutdownKindUnknown, errors.New(strings.Join(errs, "; "))
+	}
+	return ShutdownKindUnknown, nil
+}
+
+// ReasonFile reads the kind of shutdown from a file an operator writes before shutting down, e.g.
+// "poweroff". A missing file means the kind is unknown, so it is best kept where it is removed at boot.
+func ReasonFile(path string) ShutdownKindSource {
+	return ShutdownKindFunc(func() (ShutdownKind, error) {
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return ShutdownKindUnknown, nil
+		}
+		if err != nil {
+			return ShutdownKindUnknown, err
+		}
+		kind, err := ParseShutdownKind(strings.ToLower(strings.TrimSpace(string(b))))
+		if err != nil {
+			return ShutdownKindUnknown, fmt.Errorf("%s: %w", path, err)
+		}
+		return kind, nil
+	})
+}
+
+// SystemdShutdownKind tells the kind of shutdown from the shutdown scheduled by systemd, or the shutdown
+// target it is starting.
+func SystemdShutdownKind() ShutdownKindSource {
+	return systemdShutdownKind()
+}
+
+// ShutdownKindStore is implemented by data stores that can remember the kind of the last shutdown, like DataDir.
+type ShutdownKindStore interface {
+	SetShutdownKind(kind ShutdownKind) error
+	GetShutdownKind() (ShutdownKind, error)
+}
@@ -0,0 +1,68 @@
+package downtime
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+)
+
+// systemdScheduledShutdown is written by systemd-logind when a shutdown is scheduled, e.g. by shutdown -r
+const systemdScheduledShutdown = "/run/systemd/shutdown/scheduled"
+
+// systemdShutdownKinds maps the modes of scheduled shutdowns and the targets systemd starts to shut down
+var systemdShutdownKinds = map[string]ShutdownKind{
+	"reboot":          ShutdownKindReboot,
+	"kexec":           ShutdownKindReboot,
+	"poweroff":        ShutdownKindPoweroff,
+	"halt":            ShutdownKindHalt,
+	"reboot.target":   ShutdownKindReboot,
+	"kexec.target":    ShutdownKindReboot,
+	"poweroff.target": ShutdownKindPoweroff,
+	"halt.target":     ShutdownKindHalt,
+}
+
+func systemdShutdownKind() ShutdownKindSource {
+	return ShutdownKindFunc(func() (ShutdownKind, error) {
+		kind, err := scheduledShutdownKind()
+		if err != nil || kind != ShutdownKindUnknown {
+			return kind, err
+		}
+		return shutdownTargetKind()
+	})
+}
+
+// scheduledShutdownKind reads the MODE of a shutdown scheduled with systemd-logind
+func scheduledShutdownKind() (ShutdownKind, error) {
+	f, err := os.Open(systemdScheduledShutdown)
+	if errors.Is(err, os.ErrNotExist) {
+		return ShutdownKindUnknown, nil
+	}
+	if err != nil {
+		return ShutdownKindUnknown, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if mode := strings.TrimPrefix(scanner.Text(), "MODE="); mode != scanner.Text() {
+			return systemdShutdownKinds[mode], nil
+		}
+	}
+	return ShutdownKindUnknown, scanner.Err()
+}
+
+// shutdownTargetKind looks for the shutdown target among the jobs systemd is running
+func shutdownTargetKind() (ShutdownKind, error) {
+	jobs, err := systemctl("list-jobs", "--no-legend", "--plain")
+	if err != nil {
+		return ShutdownKindUnknown, err
+	}
+	for _, line := range strings.Split(jobs, "\n") {
+		for _, field := range strings.Fields(line) {
+			if kind, ok := systemdShutdownKinds[field]; ok && strings.HasSuffix(field, ".target") {
+				return kind, nil
+			}
+		}
+	}
+	return ShutdownKindUnknown, nil
+}
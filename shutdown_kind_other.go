@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package downtime
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func systemdShutdownKind() ShutdownKindSource {
+	return ShutdownKindFunc(func() (ShutdownKind, error) {
+		return ShutdownKindUnknown, fmt.Errorf("os not supported: %s", runtime.GOOS)
+	})
+}
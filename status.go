@@ -0,0 +1,194 @@
+package downtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Status is a snapshot of the state of a running Daemon.
+type Status struct {
+	// Boot is the boot time passed to Init, zero before Init succeeded.
+	Boot time.Time
+	// LastStamp is when the stamp was last updated, zero if it never was.
+	LastStamp time.Time
+	// StampErr is why the last update of the stamp failed, nil if it succeeded.
+	StampErr error
+}
+
+// Healthy reports whether the last update of the stamp succeeded.
+func (s Status) Healthy() bool {
+	return s.StampErr == nil
+}
+
+func (d *Daemon) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Status{
+		Boot:      d.bootTime,
+		LastStamp: d.lastStamp,
+		StampErr:  d.stampErr,
+	}
+}
+
+type statusJSON struct {
+	Boot          *UnixTimestamp `json:"boot,omitempty"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	LastStamp     *UnixTimestamp `json:"last_stamp,omitempty"`
+	Healthy       bool           `json:"healthy"`
+	StampError    string         `json:"stamp_error,omitempty"`
+}
+
+type outageJSON struct {
+	Down            *Event  `json:"down"`
+	Up              *Event  `json:"up"`
+	Crashed         bool    `json:"crashed"`
+	Suspended       bool    `json:"suspended"`
+	DowntimeSeconds float64 `json:"downtime_seconds"`
+}
+
+func optionalTimestamp(t time.Time) *UnixTimestamp {
+	if t.IsZero() {
+		return nil
+	}
+	ut := UnixTimestamp(t.UnixNano())
+	return &ut
+}
+
+func optionalEvent(event Event) *Event {
+	if event.What == EventTypeNone {
+		return nil
+	}
+	return &event
+}
+
+/*
+NewStatusHandler serves the state of the daemon and the outages recorded in the database at dbPath as JSON:
+
+	GET /status                                   boot time, uptime, last stamp and whether stamping works
+	GET /outages?since=<time>&until=<time>&n=<n>  outages that began in the given range, at most the last n
+
+Outages are those of the service tracked by the daemon, or of the system.
+
+Times are RFC 3339, all parameters are optional.
+*/
+func NewStatusHandler(d *Daemon, dbPath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := d.Status()
+		resp := statusJSON{
+			Boot:      optionalTimestamp(status.Boot),
+			LastStamp: optionalTimestamp(status.LastStamp),
+			Healthy:   status.Healthy(),
+		}
+		if !status.Boot.IsZero() {
+			resp.UptimeSeconds = d.clk.Since(status.Boot).Seconds()
+		}
+		if status.StampErr != nil {
+			resp.StampError = status.StampErr.Error()
+		}
+		writeJSON(w, resp)
+	})
+	mux.HandleFunc("/outages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		since, until, n, err := parseOutageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		outages, err := outagesBetween(dbPath, d.service, since, until)
+		if err != nil {
+			logger.Errorf("can not read %s: %s", dbPath, err)
+			http.Error(w, "can not read database", http.StatusInternalServerError)
+			return
+		}
+		if n >= 0 && len(outages) > n {
+			outages = outages[len(outages)-n:]
+		}
+		resp := make([]outageJSON, len(outages))
+		for i, outage := range outages {
+			resp[i] = outageJSON{
+				Down:            optionalEvent(outage.Down),
+				Up:              optionalEvent(outage.Up),
+				Crashed:         outage.Crashed(),
+				Suspended:       outage.Suspended(),
+				DowntimeSeconds: outage.Duration().Seconds(),
+			}
+		}
+		writeJSON(w, resp)
+	})
+	return mux
+}
+
+func parseOutageParams(r *http.Request) (since, until time.Time, n int, err error) {
+	query := r.URL.Query()
+	if s := query.Get("since"); s != "" {
+		since, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return since, until, n, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if s := query.Get("until"); s != "" {
+		until, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return since, until, n, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	n = -1
+	if s := query.Get("n"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return since, until, n, fmt.Errorf("invalid n: %s", s)
+		}
+	}
+	return since, until, n, nil
+}
+
+// outagesBetween returns the outages that began in [since, until), either bound may be zero
+func outagesBetween(dbPath, service string, since, until time.Time) ([]Outage, error) {
+	r, err := OpenDatabaseSegmentsSince(dbPath, since)
+	if errors.Is(err, os.ErrNotExist) {
+		// nothing recorded yet
+		return []Outage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// the up event of an outage that began before until may be after it, so only the start is bounded here
+	events, err := r.Query(Query{Since: since, Services: []string{service}})
+	if err != nil {
+		return nil, err
+	}
+	outages := []Outage{}
+	for _, outage := range Outages(events) {
+		start := outage.Down
+		if start.What == EventTypeNone {
+			start = outage.Up
+		}
+		if !until.IsZero() && !start.When.AsTime().Before(until) {
+			continue
+		}
+		outages = append(outages, outage)
+	}
+	return outages, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Warningf("failed to write response: %s", err)
+	}
+}
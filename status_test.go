@@ -0,0 +1,97 @@
+package downtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getJSON(t *testing.T, handler http.Handler, url string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+	return rec.Code
+}
+
+func TestStatusHandler(t *testing.T) {
+	store := new(mockDataStore)
+	clk := clock.NewMock()
+	dbPath := filepath.Join(t.TempDir(), DefaultDBFile)
+	db, err := OpenDatabaseWriter(dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+	d := NewDaemonWithClock(store, db, DefaultSleepSeconds*time.Second, clk)
+	handler := NewStatusHandler(d, dbPath)
+
+	var status map[string]interface{}
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/status", &status))
+	assert.Equal(t, map[string]interface{}{"healthy": true, "uptime_seconds": 0.0}, status)
+
+	var outages []map[string]interface{}
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/outages", &outages))
+	assert.Empty(t, outages)
+
+	boot := time.Date(2021, 10, 6, 1, 2, 3, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		store.boot = boot
+		clk.Set(boot.Add(time.Hour))
+		d.stamp(i%2 == 1)
+		boot = boot.Add(24 * time.Hour)
+		require.NoError(t, d.Init(boot, time.Stamp))
+	}
+	clk.Set(boot.Add(time.Minute))
+	d.stamp(false)
+
+	status = nil
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/status", &status))
+	assert.Equal(t, map[string]interface{}{
+		"boot":           "2021-10-09T01:02:03Z",
+		"last_stamp":     "2021-10-09T01:03:03Z",
+		"uptime_seconds": 60.0,
+		"healthy":        true,
+	}, status)
+
+	store.setErr = fmt.Errorf("disk full")
+	d.stamp(false)
+	status = nil
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/status", &status))
+	assert.Equal(t, false, status["healthy"])
+	assert.Equal(t, "failed to update stamp: disk full", status["stamp_error"])
+	assert.Equal(t, "2021-10-09T01:03:03Z", status["last_stamp"])
+
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/outages", &outages))
+	if assert.Len(t, outages, 3) {
+		assert.Equal(t, map[string]interface{}{
+			"down":             map[string]interface{}{"what": "Shutdown", "when": "2021-10-07T02:02:03Z"},
+			"up":               map[string]interface{}{"what": "Up", "when": "2021-10-08T01:02:03Z"},
+			"crashed":          false,
+			"suspended":        false,
+			"downtime_seconds": 23 * 3600.0,
+		}, outages[1])
+		assert.Equal(t, true, outages[0]["crashed"])
+	}
+
+	outages = nil
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/outages?since=2021-10-07T02:00:00Z&until=2021-10-08T00:00:00Z", &outages))
+	if assert.Len(t, outages, 1) {
+		assert.Equal(t, false, outages[0]["crashed"])
+	}
+	outages = nil
+	assert.Equal(t, http.StatusOK, getJSON(t, handler, "/outages?n=1", &outages))
+	if assert.Len(t, outages, 1) {
+		assert.Equal(t, "2021-10-08T02:02:03Z", outages[0]["down"].(map[string]interface{})["when"])
+	}
+
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, handler, "/outages?since=yesterday", &outages))
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, handler, "/outages?n=-1", &outages))
+}
@@ -0,0 +1,115 @@
+package downtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TrackOptions configures Track, every field but Dir has a default.
+type TrackOptions struct {
+	// Dir keeps the time stamps and the database, it is created if it does not exist.
+	Dir string
+	// Sleep is the time between stamps, DefaultSleepSeconds if zero.
+	Sleep time.Duration
+	// BootTime is when the program started, ProcessBootTime if zero.
+	BootTime time.Time
+	// TimeFormat is the layout of times in log messages, time.RFC3339 if empty.
+	TimeFormat string
+}
+
+// Tracker is the handle of the daemon started by Track.
+type Tracker struct {
+	daemon *Daemon
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+/*
+Track records the downtime of the running program, the outage since it last ran is recorded before it
+returns. The daemon keeps stamping until ctx is done or Stop is called, then it records the shutdown.
+*/
+func Track(ctx context.Context, opts TrackOptions) (*Tracker, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("no directory to track downtime in")
+	}
+	if opts.Sleep == 0 {
+		opts.Sleep = DefaultSleepSeconds * time.Second
+	}
+	if opts.BootTime.IsZero() {
+		opts.BootTime = ProcessBootTime()
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = time.RFC3339
+	}
+
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", opts.Dir, err)
+	}
+	store, err := NewDataDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := OpenDatabaseWriter(filepath.Join(opts.Dir, DefaultDBFile))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	d := NewDaemon(store, db, opts.Sleep)
+	err = d.Init(opts.BootTime, opts.TimeFormat)
+	if err != nil {
+		db.Close()
+		store.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t := &Tracker{
+		daemon: d,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(t.done)
+		err := d.Run(ctx)
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			t.err = err
+		}
+		err = db.Close()
+		if t.err == nil {
+			t.err = err
+		}
+		err = store.Close()
+		if t.err == nil {
+			t.err = err
+		}
+	}()
+	return t, nil
+}
+
+// Daemon returns the daemon, to subscribe to it or read its status.
+func (t *Tracker) Daemon() *Daemon {
+	return t.daemon
+}
+
+// LastOutage returns the outage recorded when tracking started, or nil if there was none.
+func (t *Tracker) LastOutage() *OutageReport {
+	return t.daemon.LastOutage()
+}
+
+// Done is closed once the shutdown is recorded, the database closed and the lock on Dir released.
+func (t *Tracker) Done() <-chan struct{} {
+	return t.done
+}
+
+// Stop records the shutdown, closes the database and releases Dir, it waits until all are done.
+func (t *Tracker) Stop() error {
+	t.cancel()
+	<-t.done
+	return t.err
+}
@@ -0,0 +1,53 @@
+package downtime_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrack(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "myapp", "downtime")
+	start := time.Now().Add(-time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracker, err := downtime.Track(ctx, downtime.TrackOptions{Dir: dir, BootTime: start})
+	require.NoError(t, err)
+	assert.Nil(t, tracker.LastOutage(), "nothing is known the first time")
+	assert.Equal(t, start, tracker.Daemon().Status().Boot)
+	require.NoError(t, tracker.Stop())
+	stopped := tracker.Daemon().Status().LastStamp
+	assert.False(t, stopped.IsZero(), "the shutdown is stamped before Stop returns")
+
+	// the program runs again and is stopped by its context
+	restart := time.Now()
+	tracker, err = downtime.Track(ctx, downtime.TrackOptions{Dir: dir, BootTime: restart})
+	require.NoError(t, err)
+	if outage := tracker.LastOutage(); assert.NotNil(t, outage) {
+		assert.Equal(t, downtime.NewEvent(downtime.EventTypeShutdown, stopped), outage.Down)
+		assert.Equal(t, downtime.NewEvent(downtime.EventTypeUp, restart), outage.Up)
+	}
+	cancel()
+	select {
+	case <-tracker.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker did not stop with its context")
+	}
+	assert.NoError(t, tracker.Stop())
+
+	db, err := downtime.OpenDatabaseReader(filepath.Join(dir, downtime.DefaultDBFile))
+	require.NoError(t, err)
+	defer db.Close()
+	events, err := db.All()
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	_, err = downtime.Track(context.Background(), downtime.TrackOptions{})
+	assert.Error(t, err)
+}
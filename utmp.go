@@ -0,0 +1,218 @@
+package downtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultWtmpFile is where the system keeps its login and boot history on Linux.
+const DefaultWtmpFile = "/var/log/wtmp"
+
+// UtmpType is the ut_type of a utmp record.
+type UtmpType int16
+
+const (
+	UtmpEmpty        UtmpType = 0
+	UtmpRunLevel     UtmpType = 1
+	UtmpBootTime     UtmpType = 2
+	UtmpNewTime      UtmpType = 3
+	UtmpOldTime      UtmpType = 4
+	UtmpInitProcess  UtmpType = 5
+	UtmpLoginProcess UtmpType = 6
+	UtmpUserProcess  UtmpType = 7
+	UtmpDeadProcess  UtmpType = 8
+	UtmpAccounting   UtmpType = 9
+)
+
+// UtmpRecordSize is the size of a record in utmp and wtmp files written by glibc.
+const UtmpRecordSize = 384
+
+// UtmpRecord is a single entry of a utmp or wtmp file.
+type UtmpRecord struct {
+	Type UtmpType
+	PID  int32
+	Line string
+	ID   string
+	User string
+	Host string
+	Time time.Time
+}
+
+/*
+utmpRecord is the on disk layout of struct utmp used by glibc on Linux, time is always 32 bit
+so the layout is the same for 32 and 64 bit programs. Files are in host byte order, we only
+support little endian hosts.
+*/
+type utmpRecord struct {
+	Type    int16
+	_       [2]byte // padding
+	PID     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	AddrV6  [4]int32
+	_       [20]byte // reserved
+}
+
+var utmpByteOrder = binary.LittleEndian
+
+func cString(b []byte) string {
+	n := bytes.IndexByte(b, 0)
+	if n == -1 {
+		n = len(b)
+	}
+	return string(b[:n])
+}
+
+// ReadUtmp reads all records from a utmp or wtmp file.
+func ReadUtmp(r io.Reader) ([]UtmpRecord, error) {
+	records := []UtmpRecord{}
+	for {
+		var raw utmpRecord
+		err := binary.Read(r, utmpByteOrder, &raw)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return records, fmt.Errorf("%w: utmp record %d", ErrTruncated, len(records))
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, UtmpRecord{
+			Type: UtmpType(raw.Type),
+			PID:  raw.PID,
+			Line: cString(raw.Line[:]),
+			ID:   cString(raw.ID[:]),
+			User: cString(raw.User[:]),
+			Host: cString(raw.Host[:]),
+			Time: time.Unix(int64(raw.Sec), int64(raw.Usec)*int64(time.Microsecond)),
+		})
+	}
+}
+
+func ReadUtmpFile(path string) ([]UtmpRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadUtmp(f)
+}
+
+func (rec UtmpRecord) isBoot() bool {
+	return rec.Type == UtmpBootTime || (rec.Line == "~" && rec.User == "reboot")
+}
+
+func (rec UtmpRecord) isShutdown() bool {
+	return rec.Type == UtmpRunLevel && rec.User == "shutdown"
+}
+
+func (rec UtmpRecord) isCrash() bool {
+	return rec.Type == UtmpRunLevel && rec.User == "crash"
+}
+
+/*
+UtmpEvents converts the boot history of a wtmp file to events. Every boot except the first
+results in an Up event, preceded by a Shutdown event if the system recorded a shutdown since
+the previous boot, or by a Crash event if not. The crash time is taken from a crash record
+written by WtmpWriter if there is one, otherwise it is the last record seen since the previous boot.
+*/
+func UtmpEvents(records []UtmpRecord) []Event {
+	events := []Event{}
+	booted := false
+	var lastSeen, lastBoot, shutdown, crash time.Time
+	for _, rec := range records {
+		switch {
+		case rec.isBoot():
+			if booted {
+				switch {
+				case !shutdown.IsZero():
+					events = append(events, NewEvent(EventTypeShutdown, shutdown))
+				case !crash.IsZero():
+					events = append(events, NewEvent(EventTypeCrash, crash))
+				default:
+					events = append(events, NewEvent(EventTypeCrash, lastSeen))
+				}
+				events = append(events, NewEvent(EventTypeUp, rec.Time))
+			}
+			booted = true
+			shutdown = time.Time{}
+			crash = time.Time{}
+			lastSeen = rec.Time
+			lastBoot = rec.Time
+		case rec.isCrash():
+			// crash records are written after the fact, usually right after the boot record
+			// of the boot that ended the outage, and do not count as having been seen
+			n := len(events)
+			if rec.Time.Before(lastBoot) && n >= 2 && events[n-2].What == EventTypeCrash {
+				events[n-2] = NewEvent(EventTypeCrash, rec.Time)
+			} else {
+				crash = rec.Time
+			}
+		case rec.isShutdown():
+			shutdown = rec.Time
+			lastSeen = rec.Time
+		case rec.Time.After(lastSeen):
+			lastSeen = rec.Time
+		}
+	}
+	return events
+}
+
+/*
+Backfill adds events from before the database at path was started, e.g. from UtmpEvents, to it.
+Only complete outages that ended before the first event already in the database are added, so
+history the daemon recorded itself is never duplicated. It returns the number of events added.
+*/
+func Backfill(path string, events []Event) (int, error) {
+	var added int
+	fill := func(existing []Event) ([]Event, error) {
+		var first time.Time
+		if len(existing) > 0 {
+			first = existing[0].When.AsTime()
+		}
+		backfilled := []Event{}
+		for _, outage := range Outages(events) {
+			if !outage.Complete() {
+				continue
+			}
+			if !first.IsZero() && !outage.Up.When.AsTime().Before(first) {
+				continue
+			}
+			backfilled = append(backfilled, outage.Down, outage.Up)
+		}
+		if len(backfilled) == 0 {
+			return nil, nil
+		}
+		sort.SliceStable(backfilled, func(i, j int) bool { return backfilled[i].When < backfilled[j].When })
+		added = len(backfilled)
+		return append(backfilled, existing...), nil
+	}
+
+	err := rewriteDatabase(path, func(err *RecordError) {
+		logger.Warningf("dropping unreadable %s while backfilling %s", err, path)
+	}, fill)
+	if errors.Is(err, os.ErrNotExist) {
+		var all []Event
+		all, err = fill(nil)
+		if err == nil && all != nil {
+			err = WriteDatabase(path, all)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
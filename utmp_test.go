@@ -0,0 +1,68 @@
+package downtime_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadUtmp(t *testing.T) {
+	records, err := downtime.ReadUtmpFile("./test.wtmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 8)
+	assert.Equal(t, downtime.UtmpBootTime, records[0].Type)
+	assert.Equal(t, "reboot", records[0].User)
+	assert.Equal(t, "~", records[0].Line)
+	assert.Equal(t, downtime.UtmpUserProcess, records[2].Type)
+	assert.Equal(t, "pts/0", records[2].Line)
+	assert.Equal(t, "10.0.0.2", records[2].Host)
+	assert.True(t, records[2].Time.Equal(time.Unix(1633000100, 250000000)))
+
+	expected := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeShutdown, time.Unix(1633000500, 0)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633000600, 0)),
+		downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633000700, 500000000)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633001000, 0)),
+	}
+	assert.Equal(t, expected, downtime.UtmpEvents(records))
+}
+
+func TestBackfill(t *testing.T) {
+	records, err := downtime.ReadUtmpFile("./test.wtmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), downtime.DefaultDBFile)
+	w, err := downtime.OpenDatabaseWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the daemon was installed between the second and third boot
+	recorded := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633000690, 0)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633001000, 0)),
+	}
+	for _, event := range recorded {
+		assert.NoError(t, w.Append(event))
+	}
+	assert.NoError(t, w.Close())
+
+	backfilled := downtime.UtmpEvents(records)
+	added, err := downtime.Backfill(path, backfilled)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	r, err := downtime.OpenDatabaseReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	events, err := r.All()
+	assert.NoError(t, err)
+	assert.Equal(t, append(backfilled[:2], recorded...), events)
+}
@@ -0,0 +1,103 @@
+package downtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// NewWtmpWriter writes events as utmp records to writer, so they show up in `last -x`.
+// Only the given event types are written, by default just crashes since the init system
+// already records shutdowns and boots in wtmp.
+func NewWtmpWriter(writer io.Writer, types ...EventType) *WtmpWriter {
+	if len(types) == 0 {
+		types = []EventType{EventTypeCrash}
+	}
+	return &WtmpWriter{
+		writer: writer,
+		types:  types,
+	}
+}
+
+// OpenWtmpWriter opens the wtmp file at path for appending, see NewWtmpWriter.
+func OpenWtmpWriter(path string, types ...EventType) (*WtmpWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, err
+	}
+	return NewWtmpWriter(file, types...), nil
+}
+
+type WtmpWriter struct {
+	writer io.Writer
+	types  []EventType
+}
+
+func (w *WtmpWriter) wants(what EventType) bool {
+	for _, t := range w.types {
+		if t == what {
+			return true
+		}
+	}
+	return false
+}
+
+// eventUtmpRecord converts event to the record `last` expects for it, ok is false for event types without one
+func eventUtmpRecord(event Event) (rec utmpRecord, ok bool) {
+	var user, line string
+	switch event.What {
+	case EventTypeCrash:
+		rec.Type = int16(UtmpRunLevel)
+		user, line = "crash", "~~"
+	case EventTypeShutdown:
+		rec.Type = int16(UtmpRunLevel)
+		rec.PID = '0'
+		user, line = "shutdown", "~~"
+	case EventTypeUp:
+		rec.Type = int16(UtmpBootTime)
+		user, line = "reboot", "~"
+	default:
+		return rec, false
+	}
+	copy(rec.User[:], user)
+	copy(rec.Line[:], line)
+	copy(rec.ID[:], "~~")
+	when := event.When.AsTime()
+	rec.Sec = int32(when.Unix())
+	rec.Usec = int32(when.Nanosecond() / 1000)
+	return rec, true
+}
+
+func (w *WtmpWriter) Append(event Event) error {
+	// wtmp is the history of the system, not of tracked services
+	if event.Service != "" || !w.wants(event.What) {
+		return nil
+	}
+	rec, ok := eventUtmpRecord(event)
+	if !ok {
+		return nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, UtmpRecordSize))
+	err := binary.Write(buf, utmpByteOrder, rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	sync, ok := w.writer.(syncer)
+	if ok {
+		return sync.Sync()
+	}
+	return nil
+}
+
+func (w *WtmpWriter) Close() error {
+	c, ok := w.writer.(io.Closer)
+	if ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package downtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abferm/downtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWtmpWriter(t *testing.T) {
+	fixture, err := os.ReadFile("./test.wtmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "wtmp")
+	err = os.WriteFile(path, fixture, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the daemon reports the crash after the system wrote the boot record
+	crash := downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633000750, 0))
+	w, err := downtime.OpenWtmpWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, w.Append(crash))
+	assert.NoError(t, w.Append(downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633001000, 0))))
+	assert.NoError(t, w.Close())
+
+	records, err := downtime.ReadUtmpFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 9, "only the crash is written")
+	last := records[len(records)-1]
+	assert.Equal(t, downtime.UtmpRunLevel, last.Type)
+	assert.Equal(t, "crash", last.User)
+	assert.Equal(t, "~~", last.Line)
+	assert.True(t, last.Time.Equal(crash.When.AsTime()))
+
+	events := downtime.UtmpEvents(records)
+	assert.Equal(t, crash, events[2])
+}
+
+func TestWtmpWriterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wtmp")
+	w, err := downtime.OpenWtmpWriter(path, downtime.EventTypeUp, downtime.EventTypeShutdown, downtime.EventTypeCrash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []downtime.Event{
+		downtime.NewEvent(downtime.EventTypeShutdown, time.Unix(1633000500, 0)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633000600, 0)),
+		downtime.NewEvent(downtime.EventTypeCrash, time.Unix(1633000700, 123000)),
+		downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633001000, 0)),
+	}
+	assert.NoError(t, w.Append(downtime.NewEvent(downtime.EventTypeUp, time.Unix(1633000000, 0))))
+	for _, event := range expected {
+		assert.NoError(t, w.Append(event))
+	}
+	assert.NoError(t, w.Close())
+
+	records, err := downtime.ReadUtmpFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, downtime.UtmpEvents(records))
+}